@@ -2,33 +2,143 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"siri-playlist-actions/utils"
+	"strings"
+	"sync"
+	"time"
 )
 
 // RequestBody defines the expected JSON payload
 type RequestBody struct {
-	PlaylistID string `json:"playlist_id"`
+	// PlaylistID is one or more playlist links, URIs or IDs
+	PlaylistID utils.StringList `json:"playlist_id"`
+	// Playlist is one or more aliases (see /api/aliases) or playlist IDs
+	Playlist utils.StringList `json:"playlist"`
+	// PlaylistName is a spoken playlist name, matched fuzzily against the user's playlists.
+	// Without any playlist, the user's routing rules or default playlist pick one
+	PlaylistName string `json:"playlist_name"`
+	// Track is one or more track links, URIs or IDs to add instead of the current song
+	Track utils.StringList `json:"track"`
+	// Duplicates overrides the user's duplicate policy: "skip", "allow" or "move_to_top"
+	Duplicates string `json:"duplicates"`
+	// Position overrides where tracks are inserted: "top", "bottom" or an index
+	Position *utils.InsertPosition `json:"position"`
+	// MatchTitleArtist overrides whether songs with the same title and artist count as duplicates
+	MatchTitleArtist *bool `json:"match_title_artist"`
+}
+
+// addOptions are the policies applied when adding to a playlist
+type addOptions struct {
+	Duplicates       string
+	Position         utils.InsertPosition
+	DuplicateOptions utils.DuplicateOptions
+	// Playlists holds the user's per-playlist settings, such as rotation limits
+	Playlists map[string]utils.PlaylistSettings
+}
+
+// Maximum number of tracks that can be added in a single request
+const maxTracksPerRequest = 50
+
+// Maximum number of playlists that can be added to in a single request
+const maxPlaylistsPerRequest = 10
+
+// TrackResult reports what happened to a single track
+type TrackResult struct {
+	Track  string `json:"track"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"`
+	// Reason is the content policy that blocked the track, if any
+	Reason string `json:"reason,omitempty"`
+}
+
+// PlaylistResult reports what happened in a single destination playlist
+type PlaylistResult struct {
+	PlaylistID string        `json:"playlist_id"`
+	Name       string        `json:"name"`
+	Status     string        `json:"status"`
+	Results    []TrackResult `json:"results"`
+	// Rotated lists the oldest tracks removed to keep the playlist within its limits
+	Rotated []TrackResult `json:"rotated,omitempty"`
+	// ArchiveName is the playlist the rotated tracks were moved to, if any
+	ArchiveName string `json:"archive_name,omitempty"`
+
+	// changes records what was added so it can be undone
+	changes []utils.PlaylistChange
+}
+
+// PlaylistCandidate is a possible destination returned when a spoken name is ambiguous
+type PlaylistCandidate struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
 }
 
 // Handler for /api/add-song
 func AddSongHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("add-song", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "add-song")
+
 	apiKey := r.Header.Get("X-API-Key")
 	if apiKey == "" {
 		http.Error(w, "Missing API Key header", http.StatusBadRequest)
 		return
 	}
 
-	// Parse JSON request body
+	// Parse JSON request body, which may be empty to add the current song to the default playlist
 	var requestBody RequestBody
 	err := json.NewDecoder(r.Body).Decode(&requestBody)
-	if err != nil || requestBody.PlaylistID == "" {
-		http.Error(w, "Invalid JSON body: Missing 'playlist_id'", http.StatusBadRequest)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("Invalid JSON body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := utils.ValidateDuplicatePolicy(requestBody.Duplicates); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(requestBody.PlaylistID)+len(requestBody.Playlist) > maxPlaylistsPerRequest {
+		http.Error(w, fmt.Sprintf("Too many playlists: at most %d can be added to at once", maxPlaylistsPerRequest), http.StatusBadRequest)
+		return
+	}
+
+	// Accept playlist links and URIs as well as bare IDs
+	var destinationPlaylistIDs []string
+	for _, playlist := range requestBody.PlaylistID {
+		playlistID, err := utils.ParseSpotifyID(playlist, utils.RefTypePlaylist)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid 'playlist_id': %s", err), http.StatusBadRequest)
+			return
+		}
+		destinationPlaylistIDs = append(destinationPlaylistIDs, playlistID)
+	}
+
+	// Accept track links and URIs as well as bare IDs, and podcast episodes by link or URI. Text
+	// shared from other apps often holds several links separated by whitespace
+	var trackIDs, episodeIDs []string
+	for _, track := range requestBody.Track {
+		for _, ref := range strings.Fields(track) {
+			parsed, err := utils.ParseSpotifyRef(ref, utils.RefTypeTrack)
+			if err == nil && parsed.Type != utils.RefTypeTrack && parsed.Type != utils.RefTypeEpisode {
+				err = fmt.Errorf("expected a track or episode reference, got %s", parsed.Type)
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid 'track' %s: %s", ref, err), http.StatusBadRequest)
+				return
+			}
+			if parsed.Type == utils.RefTypeEpisode {
+				episodeIDs = append(episodeIDs, parsed.ID)
+			}
+			trackIDs = append(trackIDs, parsed.ID)
+		}
+	}
+	if len(trackIDs) > maxTracksPerRequest {
+		http.Error(w, fmt.Sprintf("Too many tracks: at most %d can be added at once", maxTracksPerRequest), http.StatusBadRequest)
 		return
 	}
-	destinationPlaylistID := requestBody.PlaylistID
 
 	// Connect to database
 	redisPool, err := utils.InitRedis()
@@ -41,49 +151,673 @@ func AddSongHandler(w http.ResponseWriter, r *http.Request) {
 	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
 		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
 	}
-	userAuthData, err := utils.GetAPIKeyToUserAuthData(apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
 	if err != nil {
 		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
 		return
 	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	// Per-request policies override the user's settings
+	settings, err := utils.GetUserSettings(userAuthData.UserID, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to load settings", "error", err)
+		http.Error(w, "Error retrieving your settings", http.StatusInternalServerError)
+		return
+	}
+	options := addOptions{
+		Duplicates:       settings.DuplicatePolicy,
+		Position:         *settings.InsertPosition,
+		DuplicateOptions: utils.DuplicateOptions{MatchTitleArtist: settings.MatchTitleArtist},
+		Playlists:        settings.Playlists,
+	}
+	if requestBody.Duplicates != "" {
+		options.Duplicates = requestBody.Duplicates
+	}
+	if requestBody.Position != nil {
+		options.Position = *requestBody.Position
+	}
+	if requestBody.MatchTitleArtist != nil {
+		options.DuplicateOptions.MatchTitleArtist = *requestBody.MatchTitleArtist
+	}
 
-	songID, songName, _, _, _, err := utils.GetCurrentlyPlayingSong(userAuthData.AccessToken)
-	if err != nil || songID == "" {
-		log.Printf("Error: songId=%s, songName=%s", songID, songName)
-		log.Print(err)
-		http.Error(w, "No song is currently playing", http.StatusNotFound)
+	useSettings := len(requestBody.PlaylistID) == 0 && len(requestBody.Playlist) == 0 && requestBody.PlaylistName == ""
+	if useSettings && len(settings.Rules) == 0 && settings.DefaultPlaylistID == "" {
+		http.Error(w, "Missing 'playlist_id', 'playlist' or 'playlist_name', and no default playlist is set", http.StatusBadRequest)
 		return
 	}
 
+	// Resolve each 'playlist', preferring an alias over a playlist reference
+	for _, playlist := range requestBody.Playlist {
+		playlistID, err := utils.GetPlaylistAlias(userAuthData.UserID, playlist, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to look up playlist alias", "error", err)
+			http.Error(w, "Error retrieving playlist aliases", http.StatusInternalServerError)
+			return
+		}
+		if playlistID == "" {
+			playlistID, err = utils.ParseSpotifyID(playlist, utils.RefTypePlaylist)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s is neither an alias nor a playlist", playlist), http.StatusBadRequest)
+				return
+			}
+		}
+		destinationPlaylistIDs = append(destinationPlaylistIDs, playlistID)
+	}
+
+	// Match the spoken name against the user's playlists
+	if requestBody.PlaylistName != "" {
+		playlists, err := utils.GetUserPlaylists(userAuthData.AccessToken)
+		if err != nil {
+			logger.Error("Failed to list playlists", "error", err)
+			http.Error(w, "Error retrieving your playlists", http.StatusInternalServerError)
+			return
+		}
+
+		matches := utils.BestPlaylistMatches(requestBody.PlaylistName, playlists)
+		if len(matches) == 0 {
+			http.Error(w, fmt.Sprintf("Could not find a playlist called %s", requestBody.PlaylistName), http.StatusNotFound)
+			return
+		}
+		if len(matches) > 1 {
+			// Let the Shortcut ask the user which one they meant
+			candidates := []PlaylistCandidate{}
+			for _, match := range matches {
+				candidates = append(candidates, PlaylistCandidate{ID: match.Playlist.ID, Name: match.Playlist.Name})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusMultipleChoices)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message":    fmt.Sprintf("Found %d playlists matching %s", len(candidates), requestBody.PlaylistName),
+				"candidates": candidates,
+			})
+			return
+		}
+		destinationPlaylistIDs = append(destinationPlaylistIDs, matches[0].Playlist.ID)
+	}
+
+	// Resolve the tracks to add once, no matter how many playlists they go to
+	var tracks []utils.Track
+	var playing *utils.CurrentlyPlaying
+	if len(trackIDs) > 0 {
+		if songIDs := withoutStrings(trackIDs, episodeIDs); len(songIDs) > 0 {
+			tracks, err = utils.GetTracks(userAuthData.AccessToken, uniqueStrings(songIDs))
+		}
+		if err == nil && len(episodeIDs) > 0 {
+			var episodes []utils.Track
+			episodes, err = utils.GetEpisodes(userAuthData.AccessToken, uniqueStrings(episodeIDs))
+			tracks = append(tracks, episodes...)
+		}
+		if err != nil {
+			logger.Error("Failed to get tracks", "error", err)
+			http.Error(w, "Error retrieving tracks", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		playing, err = utils.GetCurrentlyPlayingItem(userAuthData.AccessToken)
+		if err != nil || playing == nil {
+			if err != nil {
+				logger.Error("Failed to get the currently playing song", "error", err)
+			}
+			http.Error(w, "No song is currently playing", http.StatusNotFound)
+			return
+		}
+		trackIDs = []string{playing.Track.ID}
+		tracks = []utils.Track{playing.Track}
+	}
+
+	// Without a playlist, the first of the user's rules that matches picks one, falling back to
+	// their default playlist
+	ruleName := ""
+	if useSettings {
+		ruleIndex := -1
+		if len(settings.Rules) > 0 {
+			var track utils.Track
+			if len(tracks) > 0 {
+				track = tracks[0]
+			}
+			ruleIndex = utils.MatchRule(settings.Rules, ruleContext(logger, userAuthData.AccessToken, settings, track, playing))
+		}
+
+		if ruleIndex >= 0 {
+			ruleName = settings.Rules[ruleIndex].Label(ruleIndex)
+			destinationPlaylistIDs = append(destinationPlaylistIDs, settings.Rules[ruleIndex].PlaylistID)
+		} else if settings.DefaultPlaylistID != "" {
+			destinationPlaylistIDs = append(destinationPlaylistIDs, settings.DefaultPlaylistID)
+		} else {
+			http.Error(w, "None of your rules matched this song, so say which playlist to add it to", http.StatusNotFound)
+			return
+		}
+	}
+	destinationPlaylistIDs = uniqueStrings(destinationPlaylistIDs)
+
+	// Add to every destination in parallel
+	playlistResults := make([]PlaylistResult, len(destinationPlaylistIDs))
+	var wg sync.WaitGroup
+	for i, playlistID := range destinationPlaylistIDs {
+		wg.Add(1)
+		go func(i int, playlistID string) {
+			defer wg.Done()
+			playlistResults[i] = addTracksToPlaylist(logger, userAuthData.AccessToken, playlistID, trackIDs, tracks, options)
+		}(i, playlistID)
+	}
+	wg.Wait()
+
+	// Remember what was added so it can be undone
+	action := utils.PlaylistAction{Type: utils.ActionAdd, CreatedAt: time.Now()}
+	for _, result := range playlistResults {
+		action.Changes = append(action.Changes, result.changes...)
+	}
+	if len(action.Changes) > 0 {
+		err = utils.PushAction(userAuthData.UserID, action, redisPool.Get())
+		if err != nil {
+			logger.Warn("Failed to save action for undo", "error", err)
+		}
+	}
+
+	recordHistory(logger, userAuthData.UserID, addHistory(playlistResults, utils.KeyLabel(apiKey)), redisPool)
+
+	// A single current song added to a single playlist keeps the original plain text responses
+	if len(requestBody.Track) == 0 && len(playlistResults) == 1 {
+		result := playlistResults[0]
+		status, message := http.StatusOK, ""
+		switch result.Status {
+		case "duplicate":
+			message = settings.Message(utils.MessageDuplicate, fmt.Sprintf("This song is already in your playlist %s", result.Name))
+		case "moved":
+			message = settings.Message(utils.MessageMoved, fmt.Sprintf("This song was already in %s, so it was moved to the top", result.Name))
+		case "blocked":
+			status, message = http.StatusForbidden, describeBlocked(result)
+		case "error":
+			http.Error(w, "Error adding song", http.StatusInternalServerError)
+			return
+		default:
+			message = fmt.Sprintf("Song added to %s", result.Name)
+			if options.Position != utils.InsertAtBottom {
+				message = fmt.Sprintf("Song added to %s %s", options.Position.Describe(), result.Name)
+			}
+			if rotation := describeRotation(result); rotation != "" {
+				message += ". " + rotation
+			}
+			message = settings.Message(utils.MessageAdded, message)
+		}
+		if ruleName != "" && settings.Verbosity != utils.VerbosityBrief {
+			message += fmt.Sprintf(", using your rule %s", ruleName)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(message))
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":    summarizeAddResults(playlistResults),
+		"duplicates": options.Duplicates,
+		"position":   options.Position,
+		"playlists":  playlistResults,
+	}
+	if ruleName != "" {
+		response["rule"] = ruleName
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Builds a history event for every track added, skipped or rotated out
+func addHistory(playlistResults []PlaylistResult, source string) []utils.HistoryEvent {
+	var events []utils.HistoryEvent
+	for _, result := range playlistResults {
+		for _, trackResult := range result.Results {
+			events = append(events, utils.HistoryEvent{
+				Type:         utils.HistoryAdd,
+				Track:        trackResult.Track,
+				TrackName:    trackResult.Name,
+				PlaylistID:   result.PlaylistID,
+				PlaylistName: result.Name,
+				Result:       trackResult.Status,
+				Source:       source,
+			})
+		}
+		for _, trackResult := range result.Rotated {
+			events = append(events, utils.HistoryEvent{
+				Type:         utils.HistoryRemove,
+				Track:        trackResult.Track,
+				TrackName:    trackResult.Name,
+				PlaylistID:   result.PlaylistID,
+				PlaylistName: result.Name,
+				Result:       trackResult.Status,
+				Source:       source,
+			})
+		}
+	}
+	return events
+}
+
+// Gathers what routing rules are evaluated against. Genres and playback are only fetched when
+// needed, and a failure to fetch them only means the rules that use them don't match
+func ruleContext(logger *slog.Logger, accessToken string, settings utils.UserSettings, track utils.Track, playing *utils.CurrentlyPlaying) utils.RuleContext {
+	// Rule times are in the user's time zone
+	ctx := utils.RuleContext{Now: time.Now().In(settings.Location())}
+
+	for _, rule := range settings.Rules {
+		if !rule.NeedsGenres() {
+			continue
+		}
+		var artistIDs []string
+		for _, artist := range track.Artists {
+			if artist.ID != "" {
+				artistIDs = append(artistIDs, artist.ID)
+			}
+		}
+		if len(artistIDs) > 0 {
+			genres, err := utils.GetArtistGenres(accessToken, artistIDs)
+			if err != nil {
+				logger.Warn("Failed to get artist genres", "error", err)
+			}
+			ctx.Genres = genres
+		}
+		break
+	}
+
+	if playing == nil {
+		var err error
+		playing, err = utils.GetCurrentlyPlaying(accessToken)
+		if err != nil {
+			logger.Warn("Failed to get the currently playing song", "error", err)
+		}
+	}
+	if playing != nil {
+		ctx.DeviceName = playing.Device.Name
+		ctx.DeviceType = playing.Device.Type
+		ctx.ContextType = playing.ContextType
+		ctx.ContextURI = playing.ContextURI
+	}
+
+	return ctx
+}
+
+// Adds tracks to one playlist, applying the duplicate policy and insert position, and reports a
+// result for each track
+func addTracksToPlaylist(logger *slog.Logger, accessToken, playlistID string, trackIDs []string, tracks []utils.Track, options addOptions) PlaylistResult {
 	// Get the playlist name (optional)
-	destinationPlaylistName, err := utils.GetPlaylistName(userAuthData.AccessToken, destinationPlaylistID)
+	playlistName, err := utils.GetPlaylistName(accessToken, playlistID)
 	if err != nil {
 		// If we can't retrieve the name, default to "unknown"
-		destinationPlaylistName = "unknown"
+		playlistName = "unknown"
+	}
+	playlistResult := PlaylistResult{PlaylistID: playlistID, Name: playlistName, Results: []TrackResult{}}
+
+	// Requested IDs may come back relinked to a different ID for the user's market
+	tracksByID := map[string]utils.Track{}
+	names := map[string]string{}
+	for _, track := range tracks {
+		tracksByID[track.ID] = track
+		tracksByID[track.OriginalID()] = track
+		names[track.ID] = track.Name
+		names[track.OriginalID()] = track.Name
+	}
+
+	// Every page of the playlist is checked for existing copies
+	var items []utils.PlaylistItem
+	snapshotID := ""
+	if options.Duplicates != utils.DuplicatesAllow {
+		playlist, err := utils.GetPlaylist(accessToken, playlistID)
+		if err == nil {
+			snapshotID = playlist.SnapshotID
+			items, err = utils.GetPlaylistItems(accessToken, playlistID)
+		}
+		if err != nil {
+			logger.Error("Failed to get playlist items", "error", err)
+			for _, trackID := range trackIDs {
+				playlistResult.Results = append(playlistResult.Results, TrackResult{Track: requestedURI(trackID, tracksByID), Name: names[trackID], Status: "error"})
+			}
+			playlistResult.Status = "error"
+			return playlistResult
+		}
 	}
 
-	// Check if the song is already in the playlist
-	isInPlaylist, err := utils.IsSongInPlaylist(userAuthData.AccessToken, destinationPlaylistID, songID)
+	var urisToAdd []string
+	var pending []int
+	var movePositions []int
+	seen := map[string]bool{}
+	for _, trackID := range trackIDs {
+		track, found := tracksByID[trackID]
+		result := TrackResult{Track: requestedURI(trackID, tracksByID), Name: track.Name}
+
+		var positions []int
+		if found && options.Duplicates != utils.DuplicatesAllow {
+			positions = utils.FindDuplicatePositions(items, track, options.DuplicateOptions)
+		}
+
+		if !found {
+			result.Status = "not_found"
+		} else if reason := options.Playlists[playlistID].CheckPolicy(track); reason != "" {
+			result.Status = "blocked"
+			result.Reason = reason
+		} else if seen[trackID] {
+			result.Status = "duplicate"
+		} else if len(positions) > 0 && options.Duplicates == utils.DuplicatesMoveToTop {
+			movePositions = append(movePositions, positions[0])
+			result.Status = "moved"
+		} else if len(positions) > 0 {
+			result.Status = "duplicate"
+		} else {
+			urisToAdd = append(urisToAdd, result.Track)
+			pending = append(pending, len(playlistResult.Results))
+			result.Status = "added"
+		}
+
+		seen[trackID] = true
+		playlistResult.Results = append(playlistResult.Results, result)
+	}
+
+	// Move existing copies to the top one at a time, tracking how each move shifts the others
+	for i, position := range movePositions {
+		snapshotID, err = utils.ReorderPlaylistTrack(accessToken, playlistID, position, 0, snapshotID)
+		if err != nil {
+			logger.Warn("Failed to move duplicate to the top", "error", err)
+			for j := range playlistResult.Results {
+				if playlistResult.Results[j].Status == "moved" {
+					playlistResult.Results[j].Status = "error"
+				}
+			}
+			break
+		}
+		for j := i + 1; j < len(movePositions); j++ {
+			if movePositions[j] < position {
+				movePositions[j]++
+			}
+		}
+	}
+
+	if len(urisToAdd) > 0 {
+		snapshotID, err := utils.AddTracksToPlaylist(accessToken, playlistID, urisToAdd, int(options.Position))
+		if err != nil {
+			logger.Error("Failed to add tracks", "error", err)
+			for _, i := range pending {
+				playlistResult.Results[i].Status = "error"
+			}
+		} else {
+			playlistResult.changes = addedChanges(logger, accessToken, playlistID, playlistName, snapshotID, urisToAdd, names, options.Position)
+		}
+	}
+
+	// Keep rolling playlists within their limits once they have grown
+	if settings := options.Playlists[playlistID]; settings.HasLimit() && len(playlistResult.changes) > 0 {
+		rotatePlaylist(logger, accessToken, &playlistResult, settings, trackIDs)
+	}
+
+	// The playlist status is the track status when they all agree, otherwise "partial"
+	for i, result := range playlistResult.Results {
+		if i == 0 {
+			playlistResult.Status = result.Status
+		} else if result.Status != playlistResult.Status {
+			playlistResult.Status = "partial"
+		}
+	}
+
+	return playlistResult
+}
+
+// Rotates the oldest tracks out of a playlist that has grown past its limits, archiving them
+// first if the playlist has an archive. The rotation is recorded in the result's changes, and
+// the positions of the added tracks are updated so undo can restore the playlist exactly
+func rotatePlaylist(logger *slog.Logger, accessToken string, result *PlaylistResult, settings utils.PlaylistSettings, trackIDs []string) {
+	playlist, err := utils.GetPlaylist(accessToken, result.PlaylistID)
+	var items []utils.PlaylistItem
+	if err == nil {
+		items, err = utils.GetPlaylistItems(accessToken, result.PlaylistID)
+	}
 	if err != nil {
-		http.Error(w, "Error checking whether song already exists in playlist", http.StatusInternalServerError)
+		logger.Warn("Failed to get playlist items for rotation", "error", err)
 		return
 	}
 
-	if isInPlaylist {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(fmt.Sprintf("This song is already in your playlist %s", destinationPlaylistName)))
+	// Never rotate out the songs this request added or moved
+	requested := map[string]bool{}
+	for _, trackID := range trackIDs {
+		requested[trackID] = true
+	}
+	keep := map[int]bool{}
+	for i, item := range items {
+		if item.Track != nil && (requested[item.Track.ID] || requested[item.Track.OriginalID()]) {
+			keep[i] = true
+		}
+	}
+
+	positions := utils.PlanRotation(items, settings, keep)
+	if len(positions) == 0 {
 		return
 	}
 
-	// Add song to playlist
-	err = utils.AddSongToPlaylist(userAuthData.AccessToken, destinationPlaylistID, songID)
+	// Tracks are referred to as they were saved, before any relinking
+	var uris []string
+	names := map[string]string{}
+	for _, position := range positions {
+		track := items[position].Track
+		uri := track.SavedURI()
+		uris = append(uris, uri)
+		names[uriID(uri)] = track.Name
+	}
+
+	// Archive first, so a failure leaves the tracks where they were instead of losing them
+	status := "rotated"
+	var archiveChanges []utils.PlaylistChange
+	if settings.ArchivePlaylistID != "" {
+		archiveSnapshotID, err := utils.AddTracksToPlaylist(accessToken, settings.ArchivePlaylistID, uris, int(utils.InsertAtBottom))
+		if err != nil {
+			logger.Warn("Failed to archive rotated tracks", "error", err)
+			return
+		}
+		archiveName, err := utils.GetPlaylistName(accessToken, settings.ArchivePlaylistID)
+		if err != nil {
+			archiveName = "unknown"
+		}
+		archiveChanges = addedChanges(logger, accessToken, settings.ArchivePlaylistID, archiveName, archiveSnapshotID, uris, names, utils.InsertAtBottom)
+		for i := range archiveChanges {
+			archiveChanges[i].Type = utils.ActionAdd
+			archiveChanges[i].Rotation = true
+		}
+		result.ArchiveName = archiveName
+		status = "archived"
+	}
+
+	snapshotID, err := utils.RemovePlaylistPositions(accessToken, result.PlaylistID, playlist.SnapshotID, items, positions)
 	if err != nil {
-		log.Print(err)
-		msg := err.Error()
-		http.Error(w, fmt.Sprintf("Error adding song: %s", msg), http.StatusInternalServerError)
+		logger.Warn("Failed to remove rotated tracks", "error", err)
+		result.changes = append(result.changes, archiveChanges...)
+		result.ArchiveName = ""
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Song added to %s", destinationPlaylistName)))
+	// Undo removes the added tracks before re-inserting the rotated ones, so each position is
+	// shifted by the other tracks that will be gone by then
+	var addedPositions []int
+	for i := range result.changes {
+		if position := result.changes[i].Position; position >= 0 {
+			addedPositions = append(addedPositions, position)
+			result.changes[i].Position = position - countBefore(positions, position)
+		}
+		result.changes[i].SnapshotID = snapshotID
+	}
+	for i, position := range positions {
+		track := items[position].Track
+		result.Rotated = append(result.Rotated, TrackResult{Track: track.URI, Name: track.Name, Status: status})
+		result.changes = append(result.changes, utils.PlaylistChange{
+			Type:         utils.ActionRemove,
+			TrackURI:     uris[i],
+			TrackName:    track.Name,
+			PlaylistID:   result.PlaylistID,
+			PlaylistName: result.Name,
+			Position:     position - countBefore(addedPositions, position),
+			SnapshotID:   snapshotID,
+			Rotation:     true,
+		})
+	}
+	result.changes = append(result.changes, archiveChanges...)
+}
+
+// Counts the positions that come before position
+func countBefore(positions []int, position int) int {
+	count := 0
+	for _, p := range positions {
+		if p < position {
+			count++
+		}
+	}
+	return count
+}
+
+// Explains for speech why a single song was not added, e.g. "Couldn't add Yellow to Kids because
+// explicit songs aren't allowed there"
+func describeBlocked(result PlaylistResult) string {
+	track := result.Results[0]
+	return fmt.Sprintf("Couldn't add %s to %s because %s there", track.Name, result.Name, track.Reason)
+}
+
+// Describes what was rotated out of a playlist for speech, e.g. "Moved 2 old songs from Gym to
+// Gym Archive", or "" if nothing was
+func describeRotation(result PlaylistResult) string {
+	if len(result.Rotated) == 0 {
+		return ""
+	}
+
+	tracks := result.Rotated[0].Name
+	if len(result.Rotated) > 1 {
+		tracks = fmt.Sprintf("%d old songs", len(result.Rotated))
+	}
+	if result.ArchiveName != "" {
+		return fmt.Sprintf("Moved %s from %s to %s", tracks, result.Name, result.ArchiveName)
+	}
+	return fmt.Sprintf("Rotated %s out of %s", tracks, result.Name)
+}
+
+// Builds a spoken summary such as "Added Yellow to Gym and Running. Already in Favorites"
+func summarizeAddResults(playlistResults []PlaylistResult) string {
+	var sentences []string
+
+	if len(playlistResults) > 0 && len(playlistResults[0].Results) == 1 {
+		songName := playlistResults[0].Results[0].Name
+		if songName == "" {
+			return "Could not find that song"
+		}
+
+		byStatus := map[string][]string{}
+		for _, result := range playlistResults {
+			byStatus[result.Status] = append(byStatus[result.Status], result.Name)
+		}
+		if names := byStatus["added"]; len(names) > 0 {
+			sentences = append(sentences, fmt.Sprintf("Added %s to %s", songName, utils.JoinNames(names)))
+		}
+		if names := byStatus["moved"]; len(names) > 0 {
+			sentences = append(sentences, fmt.Sprintf("Moved %s to the top of %s", songName, utils.JoinNames(names)))
+		}
+		if names := byStatus["duplicate"]; len(names) > 0 {
+			sentences = append(sentences, fmt.Sprintf("Already in %s", utils.JoinNames(names)))
+		}
+		if names := byStatus["error"]; len(names) > 0 {
+			sentences = append(sentences, fmt.Sprintf("Couldn't add to %s", utils.JoinNames(names)))
+		}
+		for _, result := range playlistResults {
+			if result.Status == "blocked" {
+				sentences = append(sentences, describeBlocked(result))
+			}
+		}
+	} else {
+		for _, result := range playlistResults {
+			added, blocked := 0, 0
+			for _, trackResult := range result.Results {
+				switch trackResult.Status {
+				case "added":
+					added++
+				case "blocked":
+					blocked++
+				}
+			}
+			sentences = append(sentences, fmt.Sprintf("Added %d of %d songs to %s", added, len(result.Results), result.Name))
+			if blocked > 0 {
+				sentences = append(sentences, fmt.Sprintf("%d were blocked by the rules for %s", blocked, result.Name))
+			}
+		}
+	}
+
+	for _, result := range playlistResults {
+		if rotation := describeRotation(result); rotation != "" {
+			sentences = append(sentences, rotation)
+		}
+	}
+
+	return strings.Join(sentences, ". ")
+}
+
+// Records where added tracks ended up, so undo can remove exactly those occurrences
+func addedChanges(logger *slog.Logger, accessToken, playlistID, playlistName, snapshotID string, uris []string, names map[string]string, insertPosition utils.InsertPosition) []utils.PlaylistChange {
+	position := int(insertPosition)
+	if insertPosition == utils.InsertAtBottom {
+		// Appended tracks are the last ones in the playlist
+		playlist, err := utils.GetPlaylist(accessToken, playlistID)
+		if err != nil {
+			logger.Warn("Failed to get playlist", "error", err)
+		} else {
+			position = playlist.Tracks.Total - len(uris)
+		}
+	}
+
+	var changes []utils.PlaylistChange
+	for i, uri := range uris {
+		change := utils.PlaylistChange{
+			TrackURI:     uri,
+			TrackName:    names[uriID(uri)],
+			PlaylistID:   playlistID,
+			PlaylistName: playlistName,
+			Position:     -1,
+			SnapshotID:   snapshotID,
+		}
+		if position >= 0 {
+			change.Position = position + i
+		}
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+// Returns the URI to add for a requested ID: the track as it was requested, before any
+// relinking, or the episode
+func requestedURI(id string, tracksByID map[string]utils.Track) string {
+	if track, found := tracksByID[id]; found && track.IsEpisode() {
+		return track.URI
+	}
+	return "spotify:track:" + id
+}
+
+// Returns the ID at the end of a track or episode URI
+func uriID(uri string) string {
+	return uri[strings.LastIndex(uri, ":")+1:]
+}
+
+// Returns values without any of the excluded ones
+func withoutStrings(values, excluded []string) []string {
+	exclude := map[string]bool{}
+	for _, value := range excluded {
+		exclude[value] = true
+	}
+	var kept []string
+	for _, value := range values {
+		if !exclude[value] {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
 }
@@ -3,6 +3,8 @@ package handler
 import (
 	"net/http"
 	"net/http/httptest"
+	"siri-playlist-actions/utils"
+	"strings"
 	"testing"
 )
 
@@ -16,3 +18,120 @@ func TestAddSongHandler_MissingAPIKey(t *testing.T) {
 		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
 	}
 }
+
+func TestAddSongHandler_InvalidTrack(t *testing.T) {
+	body := strings.NewReader(`{"playlist_id": "37i9dQZF1DXcBWIGoYBM5M", "track": ["spotify:track:4uLU6hMCjMI75M1A2tKUQC", "not-a-track"]}`)
+	req := httptest.NewRequest("POST", "/api/add-song", body)
+	req.Header.Set("X-API-Key", "test-key")
+	recorder := httptest.NewRecorder()
+
+	AddSongHandler(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestAddSongHandler_TooManyPlaylists(t *testing.T) {
+	body := strings.NewReader(`{"playlist": ["1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"]}`)
+	req := httptest.NewRequest("POST", "/api/add-song", body)
+	req.Header.Set("X-API-Key", "test-key")
+	recorder := httptest.NewRecorder()
+
+	AddSongHandler(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestSummarizeAddResults_SingleSong(t *testing.T) {
+	results := []PlaylistResult{
+		{Name: "Gym", Status: "added", Results: []TrackResult{{Name: "Yellow", Status: "added"}}},
+		{Name: "Running", Status: "added", Results: []TrackResult{{Name: "Yellow", Status: "added"}}},
+		{Name: "Favorites", Status: "duplicate", Results: []TrackResult{{Name: "Yellow", Status: "duplicate"}}},
+	}
+
+	message := summarizeAddResults(results)
+
+	expected := "Added Yellow to Gym and Running. Already in Favorites"
+	if message != expected {
+		t.Errorf("expected %q, got %q", expected, message)
+	}
+}
+
+func TestAddSongHandler_InvalidDuplicatePolicy(t *testing.T) {
+	body := strings.NewReader(`{"playlist_id": "37i9dQZF1DXcBWIGoYBM5M", "duplicates": "sometimes"}`)
+	req := httptest.NewRequest("POST", "/api/add-song", body)
+	req.Header.Set("X-API-Key", "test-key")
+	recorder := httptest.NewRecorder()
+
+	AddSongHandler(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestSummarizeAddResults_Rotation(t *testing.T) {
+	results := []PlaylistResult{
+		{
+			Name:        "Discoveries",
+			Status:      "added",
+			Results:     []TrackResult{{Name: "Yellow", Status: "added"}},
+			Rotated:     []TrackResult{{Name: "Clocks", Status: "archived"}, {Name: "Fix You", Status: "archived"}},
+			ArchiveName: "Discoveries Archive",
+		},
+	}
+
+	message := summarizeAddResults(results)
+
+	expected := "Added Yellow to Discoveries. Moved 2 old songs from Discoveries to Discoveries Archive"
+	if message != expected {
+		t.Errorf("expected %q, got %q", expected, message)
+	}
+}
+
+func TestSummarizeAddResults_Blocked(t *testing.T) {
+	results := []PlaylistResult{
+		{Name: "Gym", Status: "added", Results: []TrackResult{{Name: "Yellow", Status: "added"}}},
+		{Name: "Kids Car Ride", Status: "blocked", Results: []TrackResult{{Name: "Yellow", Status: "blocked", Reason: "explicit songs aren't allowed"}}},
+	}
+
+	message := summarizeAddResults(results)
+
+	expected := "Added Yellow to Gym. Couldn't add Yellow to Kids Car Ride because explicit songs aren't allowed there"
+	if message != expected {
+		t.Errorf("expected %q, got %q", expected, message)
+	}
+}
+
+func TestAddSongHandler_AlbumIsNotATrack(t *testing.T) {
+	body := strings.NewReader(`{"playlist_id": "37i9dQZF1DXcBWIGoYBM5M", "track": ["spotify:album:4uLU6hMCjMI75M1A2tKUQC"]}`)
+	req := httptest.NewRequest("POST", "/api/add-song", body)
+	req.Header.Set("X-API-Key", "test-key")
+	recorder := httptest.NewRecorder()
+
+	AddSongHandler(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestRequestedURI(t *testing.T) {
+	tracksByID := map[string]utils.Track{
+		"episode1": {ID: "episode1", URI: "spotify:episode:episode1"},
+		"relinked": {ID: "relinked", URI: "spotify:track:relinked"},
+	}
+
+	if got := requestedURI("episode1", tracksByID); got != "spotify:episode:episode1" {
+		t.Errorf("expected the episode URI, got %q", got)
+	}
+	if got := requestedURI("original", tracksByID); got != "spotify:track:original" {
+		t.Errorf("expected the requested track URI, got %q", got)
+	}
+	if got := uriID("spotify:episode:episode1"); got != "episode1" {
+		t.Errorf("expected the episode ID, got %q", got)
+	}
+}
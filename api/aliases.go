@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"siri-playlist-actions/utils"
+)
+
+// AliasRequestBody defines the expected JSON payload for creating, updating and deleting aliases
+type AliasRequestBody struct {
+	Alias      string `json:"alias"`
+	PlaylistID string `json:"playlist_id"`
+}
+
+// Handler for /api/aliases
+//
+//	GET    lists all aliases
+//	POST   creates a new alias
+//	PUT    points an existing alias at a different playlist
+//	DELETE removes an alias
+func AliasesHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("aliases", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "aliases")
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		http.Error(w, "Missing API Key", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse JSON request body (not needed to list aliases)
+	var requestBody AliasRequestBody
+	if r.Method != http.MethodGet {
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil || utils.NormalizeAlias(requestBody.Alias) == "" {
+			http.Error(w, "Invalid JSON body: Missing 'alias'", http.StatusBadRequest)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			if requestBody.PlaylistID == "" {
+				http.Error(w, "Invalid JSON body: Missing 'playlist_id'", http.StatusBadRequest)
+				return
+			}
+
+			// Accept playlist links and URIs as well as bare IDs
+			requestBody.PlaylistID, err = utils.ParseSpotifyID(requestBody.PlaylistID, utils.RefTypePlaylist)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid 'playlist_id': %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+		return
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	alias := utils.NormalizeAlias(requestBody.Alias)
+
+	switch r.Method {
+	case http.MethodGet:
+		aliases, err := utils.GetPlaylistAliases(userAuthData.UserID, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to list aliases", "error", err)
+			http.Error(w, "Error retrieving aliases", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"aliases": aliases})
+
+	case http.MethodPost, http.MethodPut:
+		existing, err := utils.GetPlaylistAlias(userAuthData.UserID, alias, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to look up playlist alias", "error", err)
+			http.Error(w, "Error retrieving aliases", http.StatusInternalServerError)
+			return
+		}
+		if r.Method == http.MethodPost && existing != "" {
+			http.Error(w, fmt.Sprintf("The alias %s already exists", alias), http.StatusConflict)
+			return
+		}
+		if r.Method == http.MethodPut && existing == "" {
+			http.Error(w, fmt.Sprintf("The alias %s does not exist", alias), http.StatusNotFound)
+			return
+		}
+
+		// Make sure the playlist exists before saving the alias
+		playlistName, err := utils.GetPlaylistName(userAuthData.AccessToken, requestBody.PlaylistID)
+		if err != nil {
+			logger.Error("Failed to get playlist name", "error", err)
+			http.Error(w, "Playlist not found", http.StatusNotFound)
+			return
+		}
+
+		err = utils.SetPlaylistAlias(userAuthData.UserID, alias, requestBody.PlaylistID, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to save alias", "error", err)
+			http.Error(w, "Error saving alias", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		w.Write([]byte(fmt.Sprintf("%s now points to %s", alias, playlistName)))
+
+	case http.MethodDelete:
+		deleted, err := utils.DeletePlaylistAlias(userAuthData.UserID, alias, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to delete alias", "error", err)
+			http.Error(w, "Error deleting alias", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, fmt.Sprintf("The alias %s does not exist", alias), http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(fmt.Sprintf("Alias %s removed", alias)))
+	}
+}
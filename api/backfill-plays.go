@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"siri-playlist-actions/utils"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Most users a backfill handles, so a run finishes within the function timeout however many
+// users there are. The rest are handled by the following runs
+const backfillUsersPerRun = 50
+
+// BackfillPlaysHandler is run by Vercel Cron. For the next batch of users it archives the tracks
+// played since their last backfill, since Spotify only keeps the last 50
+func BackfillPlaysHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("backfill-plays", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "backfill-plays")
+
+	// Vercel Cron sends the CRON_SECRET environment variable as a bearer token
+	cronSecret := os.Getenv("CRON_SECRET")
+	if cronSecret == "" || r.Header.Get("Authorization") != "Bearer "+cronSecret {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	userIDs, err := utils.NextUserBatch("backfill-plays", backfillUsersPerRun, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to list users", "error", err)
+		http.Error(w, "Error listing users", http.StatusInternalServerError)
+		return
+	}
+
+	// One user's failure, e.g. a revoked token, shouldn't stop the others
+	added, failed := 0, 0
+	for _, userID := range userIDs {
+		count, err := backfillPlays(logger, userID, redisPool)
+		if err != nil {
+			logger.Error("Failed to backfill plays", "user_id", userID, "error", err)
+			failed++
+			continue
+		}
+		added += count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"users":  len(userIDs),
+		"plays":  added,
+		"failed": failed,
+	})
+}
+
+// Archives the tracks a user played since the last backfill, returning how many were new
+func backfillPlays(logger *slog.Logger, userID string, redisPool *redis.Pool) (int, error) {
+	apiKey, err := utils.GetUserIDToAPIKey(userID, redisPool.Get())
+	if err != nil {
+		return 0, err
+	}
+	if apiKey == "" {
+		return 0, fmt.Errorf("no API key")
+	}
+
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		return 0, err
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	cursor, err := utils.GetPlaysCursor(userID, redisPool.Get())
+	if err != nil {
+		return 0, err
+	}
+	items, err := utils.GetRecentlyPlayed(userAuthData.AccessToken, cursor)
+	if err != nil {
+		return 0, err
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	var plays []utils.Play
+	for _, item := range items {
+		plays = append(plays, utils.NewPlay(item))
+	}
+	added, err := utils.StorePlays(userID, plays, redisPool.Get())
+	if err != nil {
+		return added, err
+	}
+
+	// Items are oldest first, so the last one is where the next backfill continues
+	err = utils.SetPlaysCursor(userID, items[len(items)-1].PlayedAt, redisPool.Get())
+	return added, err
+}
@@ -2,13 +2,16 @@ package handler
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"siri-playlist-actions/utils"
 )
 
 // Handler for /api/callback
 func CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("callback", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "callback")
+
 	code := r.URL.Query().Get("code")
 	if code == "" {
 		http.Error(w, "Missing authorization code", http.StatusBadRequest)
@@ -25,7 +28,7 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	// Exchange code for token
 	token, err := utils.ExchangeCodeForToken(code)
 	if err != nil {
-		log.Print(err)
+		logger.Error("Failed to exchange authorization code", "error", err)
 		http.Error(w, "Error exchanging code for token", http.StatusInternalServerError)
 		return
 	}
@@ -33,7 +36,7 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	// Fetch user ID
 	userID, err := utils.GetSpotifyUserID(token.AccessToken)
 	if err != nil {
-		log.Print(err)
+		logger.Error("Failed to get Spotify user ID", "error", err)
 		http.Error(w, "Error fetching Spotify user ID", http.StatusInternalServerError)
 		return
 	}
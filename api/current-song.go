@@ -2,13 +2,16 @@ package handler
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"siri-playlist-actions/utils"
 )
 
 // Handler for /api/current-song
 func CurrentSongHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("current-song", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "current-song")
+
 	apiKey := r.Header.Get("X-API-Key")
 	if apiKey == "" {
 		http.Error(w, "Missing API Key", http.StatusUnauthorized)
@@ -26,11 +29,12 @@ func CurrentSongHandler(w http.ResponseWriter, r *http.Request) {
 	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
 		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
 	}
-	userAuthData, err := utils.GetAPIKeyToUserAuthData(apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
 	if err != nil {
 		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
 		return
 	}
+	logger = logger.With("user_id", userAuthData.UserID)
 
 	songID, songName, artistName, _, playlistName, err := utils.GetCurrentlyPlayingSong(userAuthData.AccessToken)
 	if songID == "" {
@@ -38,7 +42,7 @@ func CurrentSongHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err != nil {
-		log.Print(err)
+		logger.Error("Failed to get the currently playing song", "error", err)
 		http.Error(w, "Error getting currently playing song", http.StatusInternalServerError)
 		return
 	}
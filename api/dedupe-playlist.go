@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"siri-playlist-actions/utils"
+	"time"
+)
+
+// DedupeRequestBody defines the expected JSON payload for /api/dedupe-playlist
+type DedupeRequestBody struct {
+	PlaylistID string `json:"playlist_id"`
+	// Playlist is either an alias (see /api/aliases) or a playlist ID. Without either, the
+	// playlist that is currently playing is deduplicated
+	Playlist string `json:"playlist"`
+	// DryRun lists the copies that would be removed without removing them
+	DryRun bool `json:"dry_run"`
+}
+
+// DedupeRemoval is a duplicate copy that was, or would be, removed
+type DedupeRemoval struct {
+	Track        string `json:"track"`
+	Name         string `json:"name"`
+	Position     int    `json:"position"`
+	KeptPosition int    `json:"kept_position"`
+}
+
+// DedupePlaylistHandler removes every duplicate from a playlist, keeping the earliest-added copy
+// of each song
+func DedupePlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("dedupe-playlist", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "dedupe-playlist")
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		http.Error(w, "Missing API Key", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse JSON request body, which may be empty to deduplicate the current playlist
+	var requestBody DedupeRequestBody
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("Invalid JSON body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	playlistID := ""
+	if requestBody.PlaylistID != "" {
+		playlistID, err = utils.ParseSpotifyID(requestBody.PlaylistID, utils.RefTypePlaylist)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid 'playlist_id': %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+		return
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	// Resolve the playlist, preferring an alias over a playlist reference
+	if playlistID == "" && requestBody.Playlist != "" {
+		playlistID, err = utils.GetPlaylistAlias(userAuthData.UserID, requestBody.Playlist, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to look up playlist alias", "error", err)
+			http.Error(w, "Error retrieving playlist aliases", http.StatusInternalServerError)
+			return
+		}
+		if playlistID == "" {
+			playlistID, err = utils.ParseSpotifyID(requestBody.Playlist, utils.RefTypePlaylist)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s is neither an alias nor a playlist", requestBody.Playlist), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if playlistID == "" {
+		playing, err := utils.GetCurrentlyPlaying(userAuthData.AccessToken)
+		if err != nil {
+			logger.Error("Failed to get the currently playing song", "error", err)
+			http.Error(w, "Error retrieving currently playing song", http.StatusInternalServerError)
+			return
+		}
+		if playing == nil || playing.PlaylistID == "" {
+			http.Error(w, "Nothing is playing from a playlist, so specify 'playlist_id' or 'playlist'", http.StatusNotFound)
+			return
+		}
+		playlistID = playing.PlaylistID
+	}
+
+	// Check if the user may edit the playlist, unless we are only listing duplicates
+	permission, playlist, err := utils.GetPlaylistPermission(userAuthData.AccessToken, playlistID, userAuthData.UserID)
+	if err != nil {
+		logger.Error("Failed to check playlist permission", "error", err)
+		http.Error(w, "Error checking playlist permissions", http.StatusInternalServerError)
+		return
+	}
+	if permission == utils.PlaylistNotFound {
+		http.Error(w, "The playlist could not be found", http.StatusNotFound)
+		return
+	}
+	if !requestBody.DryRun && !permission.CanEdit() {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(fmt.Sprintf("%s is read-only for you, so we cannot remove its duplicates", playlist.Name)))
+		return
+	}
+
+	// Scan every page. The snapshot was read first, so if the playlist changes in between the
+	// removal is rejected instead of hitting the wrong items
+	items, err := utils.GetPlaylistItems(userAuthData.AccessToken, playlistID)
+	if err != nil {
+		logger.Error("Failed to get playlist items", "error", err)
+		http.Error(w, "Error retrieving playlist tracks", http.StatusInternalServerError)
+		return
+	}
+
+	plan := utils.PlanDedupe(items, utils.DuplicateOptions{})
+	removals := []DedupeRemoval{}
+	var positions []int
+	for _, removal := range plan {
+		removals = append(removals, DedupeRemoval{
+			Track:        removal.Track.URI,
+			Name:         removal.Track.Name,
+			Position:     removal.Position,
+			KeptPosition: removal.KeptPosition,
+		})
+		positions = append(positions, removal.Position)
+	}
+
+	duplicates := fmt.Sprintf("%d duplicates", len(plan))
+	if len(plan) == 1 {
+		duplicates = "1 duplicate"
+	}
+
+	var message string
+	switch {
+	case len(plan) == 0:
+		message = fmt.Sprintf("%s has no duplicates", playlist.Name)
+	case requestBody.DryRun:
+		message = fmt.Sprintf("Would remove %s from %s", duplicates, playlist.Name)
+	default:
+		snapshotID, err := utils.RemovePlaylistPositions(userAuthData.AccessToken, playlistID, playlist.SnapshotID, items, positions)
+		if err != nil {
+			logger.Error("Failed to remove tracks", "error", err)
+			http.Error(w, fmt.Sprintf("Error removing duplicates from %s", playlist.Name), http.StatusInternalServerError)
+			return
+		}
+		message = fmt.Sprintf("Removed %s from %s", duplicates, playlist.Name)
+
+		action := utils.PlaylistAction{Type: utils.ActionRemove, CreatedAt: time.Now()}
+		for _, removal := range plan {
+			action.Changes = append(action.Changes, utils.PlaylistChange{
+				TrackURI:     removal.Track.SavedURI(),
+				TrackName:    removal.Track.Name,
+				PlaylistID:   playlistID,
+				PlaylistName: playlist.Name,
+				Position:     removal.Position,
+				SnapshotID:   snapshotID,
+			})
+		}
+		err = utils.PushAction(userAuthData.UserID, action, redisPool.Get())
+		if err != nil {
+			logger.Warn("Failed to save action for undo", "error", err)
+		}
+
+		var history []utils.HistoryEvent
+		for _, change := range action.Changes {
+			history = append(history, utils.HistoryEvent{
+				Type:         utils.HistoryRemove,
+				Track:        change.TrackURI,
+				TrackName:    change.TrackName,
+				PlaylistID:   playlistID,
+				PlaylistName: playlist.Name,
+				Result:       "duplicate",
+				Source:       utils.KeyLabel(apiKey),
+			})
+		}
+		recordHistory(logger, userAuthData.UserID, history, redisPool)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  message,
+		"dry_run":  requestBody.DryRun,
+		"removals": removals,
+	})
+}
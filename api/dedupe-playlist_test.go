@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDedupePlaylistHandler_MissingAPIKey(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/dedupe-playlist", nil)
+	recorder := httptest.NewRecorder()
+
+	DedupePlaylistHandler(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
+
+func TestDedupePlaylistHandler_InvalidPlaylistID(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/dedupe-playlist", strings.NewReader(`{"playlist_id": "not a playlist"}`))
+	req.Header.Set("X-API-Key", "test-key")
+	recorder := httptest.NewRecorder()
+
+	DedupePlaylistHandler(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"siri-playlist-actions/utils"
+)
+
+// Maximum number of deliveries attempted per cron run
+const maxCronWebhookDeliveries = 500
+
+// DeliverWebhooksHandler is run by Vercel Cron, daily by default or every minute where the plan
+// allows it. It delivers the queued webhook events and the retries that are due
+func DeliverWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("deliver-webhooks", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "deliver-webhooks")
+
+	// Vercel Cron sends the CRON_SECRET environment variable as a bearer token
+	cronSecret := os.Getenv("CRON_SECRET")
+	if cronSecret == "" || r.Header.Get("Authorization") != "Bearer "+cronSecret {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	attempted := processWebhookQueue(logger, maxCronWebhookDeliveries, redisPool)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"attempted": attempted})
+}
@@ -0,0 +1,18 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"siri-playlist-actions/utils"
+)
+
+// HealthzHandler reports that the function is running, for liveness checks. It doesn't check
+// dependencies; /api/readyz does. Neither waits on Redis to store its metrics
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackProbe("healthz", w)
+	defer done()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
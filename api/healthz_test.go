@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthzHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/healthz", nil)
+	recorder := httptest.NewRecorder()
+
+	HealthzHandler(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if !strings.Contains(recorder.Body.String(), `"status":"ok"`) {
+		t.Errorf("expected an ok status, got %q", recorder.Body.String())
+	}
+}
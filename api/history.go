@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"siri-playlist-actions/utils"
+	"strconv"
+	"time"
+)
+
+// Number of events returned per page, unless 'limit' says otherwise
+const defaultHistoryLimit = 50
+
+// Maximum number of events returned per page
+const maxHistoryLimit = 1000
+
+// Handler for /api/history, which lists what was done on the user's behalf, newest first
+//
+//	from, to   limit the time range, as RFC 3339 times or dates in the user's time zone
+//	cursor     continues from the 'next_cursor' of the previous page
+//	limit      is the page size, at most 1000
+//	format     is "json" (the default) or "csv"
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("history", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "history")
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		http.Error(w, "Missing API Key", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, fmt.Sprintf("Invalid 'format' %q: use \"json\" or \"csv\"", format), http.StatusBadRequest)
+		return
+	}
+	query := utils.HistoryQuery{Cursor: params.Get("cursor"), Limit: defaultHistoryLimit}
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > maxHistoryLimit {
+			http.Error(w, fmt.Sprintf("Invalid 'limit': use a number from 1 to %d", maxHistoryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+		return
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	settings, err := utils.GetUserSettings(userAuthData.UserID, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to load settings", "error", err)
+		http.Error(w, "Error retrieving your settings", http.StatusInternalServerError)
+		return
+	}
+	if query.From, err = parseHistoryTime(params.Get("from"), settings.Location(), false); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'from': %s", err), http.StatusBadRequest)
+		return
+	}
+	if query.To, err = parseHistoryTime(params.Get("to"), settings.Location(), true); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'to': %s", err), http.StatusBadRequest)
+		return
+	}
+
+	events, nextCursor, err := utils.GetHistory(userAuthData.UserID, query, redisPool.Get())
+	if errors.Is(err, utils.ErrInvalidCursor) {
+		http.Error(w, "Invalid 'cursor'", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to get history", "error", err)
+		http.Error(w, "Error retrieving history", http.StatusInternalServerError)
+		return
+	}
+
+	if nextCursor != "" {
+		w.Header().Set("X-Next-Cursor", nextCursor)
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="history.csv"`)
+		writer := csv.NewWriter(w)
+		writer.Write(append([]string{"id", "time"}, utils.HistoryFields...))
+		for _, event := range events {
+			writer.Write([]string{
+				event.ID,
+				event.Time.Format(time.RFC3339),
+				event.Type,
+				event.Track,
+				event.TrackName,
+				event.PlaylistID,
+				event.PlaylistName,
+				event.Result,
+				event.Source,
+			})
+		}
+		writer.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events":      events,
+		"next_cursor": nextCursor,
+	})
+}
+
+// Parses an RFC 3339 time or a date. A date is the start of that day in location, or its end
+// when endOfDay is set, so a range of dates includes both days
+func parseHistoryTime(value string, location *time.Location, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("use an RFC 3339 time or a YYYY-MM-DD date")
+	}
+	if endOfDay {
+		return day.AddDate(0, 0, 1).Add(-time.Millisecond), nil
+	}
+	return day, nil
+}
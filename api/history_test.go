@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHistoryHandler_MissingAPIKey(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/history", nil)
+	recorder := httptest.NewRecorder()
+
+	HistoryHandler(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
+
+func TestHistoryHandler_InvalidParameters(t *testing.T) {
+	for _, query := range []string{"format=xml", "limit=0", "limit=5000", "limit=ten"} {
+		req := httptest.NewRequest("GET", "/api/history?"+query, nil)
+		req.Header.Set("X-API-Key", "test-key")
+		recorder := httptest.NewRecorder()
+
+		HistoryHandler(recorder, req)
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, recorder.Code)
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package handler
 
 import (
-	"fmt"
 	"net/http"
+	"siri-playlist-actions/utils"
 	"text/template"
 )
 
 // LandingHandler serves the landing page
 func LandingHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("landing", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "landing")
+
 	// Define the HTML template
 	tmpl := `
 		<!DOCTYPE html>
@@ -95,13 +99,15 @@ func LandingHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse and execute the template
 	t, err := template.New("landing").Parse(tmpl)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing template: %s", err), http.StatusInternalServerError)
+		logger.Error("Failed to parse template", "error", err)
+		http.Error(w, "Error parsing template", http.StatusInternalServerError)
 		return
 	}
 
 	// Render the template
 	err = t.Execute(w, nil)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error rendering template: %s", err), http.StatusInternalServerError)
+		logger.Error("Failed to render template", "error", err)
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
 	}
 }
@@ -5,10 +5,16 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"siri-playlist-actions/utils"
 )
 
 // Handler for /api/login
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("login", w)
+	defer done()
+	// Nothing to log, but the response still gets its X-Request-Id
+	utils.RequestLogger(w, r, "login")
+
 	spotifyClientID := os.Getenv("SPOTIFY_CLIENT_ID")
 	redirectURI := os.Getenv("REDIRECT_URI")
 
@@ -16,7 +22,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		"https://accounts.spotify.com/authorize?client_id=%s&response_type=code&redirect_uri=%s&scope=%s",
 		spotifyClientID,
 		url.QueryEscape(redirectURI),
-		url.QueryEscape("user-read-playback-state user-modify-playback-state playlist-modify-public playlist-modify-private"),
+		url.QueryEscape("user-read-playback-state user-modify-playback-state playlist-read-private playlist-read-collaborative playlist-modify-public playlist-modify-private user-library-read user-library-modify user-read-recently-played"),
 	)
 
 	http.Redirect(w, r, authURL, http.StatusFound)
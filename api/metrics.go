@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"siri-playlist-actions/utils"
+)
+
+// Handler for /metrics, which reports request counts and latencies, Spotify calls, token
+// refreshes and Redis connection stats in the Prometheus text format. Set METRICS_TOKEN to
+// require it as a bearer token
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("metrics", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "metrics")
+
+	metricsToken := os.Getenv("METRICS_TOKEN")
+	if metricsToken != "" && !utils.HasBearerToken(r, metricsToken) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	text, err := utils.GetMetricsText(redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to get metrics", "error", err)
+		http.Error(w, "Error retrieving metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(text))
+}
@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"siri-playlist-actions/utils"
+	"time"
+)
+
+// MoveRequestBody defines the expected JSON payload for /api/move-song
+type MoveRequestBody struct {
+	PlaylistID string `json:"playlist_id"`
+	// Playlist is either an alias (see /api/aliases) or a playlist ID
+	Playlist string `json:"playlist"`
+	// Skip skips to the next track once the song has been moved
+	Skip bool `json:"skip"`
+}
+
+// MoveSongHandler moves the currently playing song from the playlist it is playing from to
+// another playlist
+func MoveSongHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("move-song", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "move-song")
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		http.Error(w, "Missing API Key", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse JSON request body
+	var requestBody MoveRequestBody
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil || (requestBody.PlaylistID == "" && requestBody.Playlist == "") {
+		http.Error(w, "Invalid JSON body: Missing 'playlist_id' or 'playlist'", http.StatusBadRequest)
+		return
+	}
+
+	// Accept playlist links and URIs as well as bare IDs
+	destinationPlaylistID := ""
+	if requestBody.PlaylistID != "" {
+		destinationPlaylistID, err = utils.ParseSpotifyID(requestBody.PlaylistID, utils.RefTypePlaylist)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid 'playlist_id': %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+		return
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	settings, err := utils.GetUserSettings(userAuthData.UserID, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to load settings", "error", err)
+		http.Error(w, "Error retrieving your settings", http.StatusInternalServerError)
+		return
+	}
+
+	// Resolve the destination playlist, preferring an alias over a playlist reference
+	if destinationPlaylistID == "" {
+		destinationPlaylistID, err = utils.GetPlaylistAlias(userAuthData.UserID, requestBody.Playlist, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to look up playlist alias", "error", err)
+			http.Error(w, "Error retrieving playlist aliases", http.StatusInternalServerError)
+			return
+		}
+		if destinationPlaylistID == "" {
+			destinationPlaylistID, err = utils.ParseSpotifyID(requestBody.Playlist, utils.RefTypePlaylist)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s is neither an alias nor a playlist", requestBody.Playlist), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	// Get currently playing song
+	playing, err := utils.GetCurrentlyPlaying(userAuthData.AccessToken)
+	if err != nil {
+		logger.Error("Failed to get the currently playing song", "error", err)
+		http.Error(w, "Error retrieving currently playing song", http.StatusInternalServerError)
+		return
+	}
+
+	if playing == nil {
+		http.Error(w, "No song is currently playing", http.StatusNotFound)
+		return
+	}
+	songID, songName := playing.Track.ID, playing.Track.Name
+	sourcePlaylistID, sourcePlaylistName := playing.PlaylistID, playing.PlaylistName
+
+	if sourcePlaylistID == "" {
+		http.Error(w, "The song is not playing from a playlist, so it cannot be moved", http.StatusNotFound)
+		return
+	}
+
+	if sourcePlaylistID == destinationPlaylistID {
+		http.Error(w, fmt.Sprintf("The song is already playing from %s", sourcePlaylistName), http.StatusBadRequest)
+		return
+	}
+
+	// Check if the user may edit the playlist
+	permission, sourcePlaylist, err := utils.GetPlaylistPermission(userAuthData.AccessToken, sourcePlaylistID, userAuthData.UserID)
+	if err != nil {
+		logger.Error("Failed to check playlist permission", "error", err)
+		http.Error(w, "Error checking playlist permissions", http.StatusInternalServerError)
+		return
+	}
+	switch permission {
+	case utils.PlaylistNotFound:
+		http.Error(w, "The current playlist could not be found", http.StatusNotFound)
+		return
+	case utils.PlaylistReadOnly:
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("The current playlist is read-only for you, so we cannot move this song"))
+		return
+	}
+
+	// Find where the song is, pinned to the version of the source playlist we just checked
+	items, err := utils.GetPlaylistItems(userAuthData.AccessToken, sourcePlaylistID)
+	if err != nil {
+		logger.Error("Failed to get playlist items", "error", err)
+		http.Error(w, "Error retrieving playlist tracks", http.StatusInternalServerError)
+		return
+	}
+	nextTrackID := ""
+	queue, err := utils.GetQueue(userAuthData.AccessToken)
+	if err != nil {
+		logger.Warn("Failed to get queue", "error", err)
+	} else if len(queue) > 0 {
+		nextTrackID = queue[0].ID
+	}
+	position := utils.FindPlayingPosition(items, songID, nextTrackID)
+	if position < 0 {
+		http.Error(w, "The song could not be found in the playlist", http.StatusNotFound)
+		return
+	}
+
+	// Get the destination playlist name (optional)
+	destinationPlaylistName, err := utils.GetPlaylistName(userAuthData.AccessToken, destinationPlaylistID)
+	if err != nil {
+		// If we can't retrieve the name, default to "unknown"
+		destinationPlaylistName = "unknown"
+	}
+
+	// Moving a song adds it to the destination, so the destination's content policies apply
+	if reason := settings.Playlists[destinationPlaylistID].CheckPolicy(playing.Track); reason != "" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(fmt.Sprintf("Couldn't move %s to %s because %s there", songName, destinationPlaylistName, reason)))
+		return
+	}
+
+	// Add the song to the destination first, unless it is already there
+	trackURI := fmt.Sprintf("spotify:track:%s", songID)
+	action := utils.PlaylistAction{Type: utils.ActionMove, CreatedAt: time.Now()}
+	// Read every page, and match relinked copies and the same recording the way add-song does
+	destinationItems, err := utils.GetPlaylistItems(userAuthData.AccessToken, destinationPlaylistID)
+	if err != nil {
+		logger.Error("Failed to get playlist items", "error", err)
+		http.Error(w, "Error checking whether song already exists in playlist", http.StatusInternalServerError)
+		return
+	}
+	duplicateOptions := utils.DuplicateOptions{MatchTitleArtist: settings.MatchTitleArtist}
+	isInPlaylist := len(utils.FindDuplicatePositions(destinationItems, playing.Track, duplicateOptions)) > 0
+	if !isInPlaylist {
+		addSnapshotID, err := utils.AddTracksToPlaylist(userAuthData.AccessToken, destinationPlaylistID, []string{trackURI}, -1)
+		if err != nil {
+			logger.Error("Failed to add tracks", "error", err)
+			http.Error(w, fmt.Sprintf("Error adding song to %s", destinationPlaylistName), http.StatusInternalServerError)
+			return
+		}
+
+		// Appended after the items read above
+		addedPosition := len(destinationItems)
+		action.Changes = append(action.Changes, utils.PlaylistChange{
+			Type:         utils.ActionAdd,
+			TrackURI:     trackURI,
+			TrackName:    songName,
+			PlaylistID:   destinationPlaylistID,
+			PlaylistName: destinationPlaylistName,
+			Position:     addedPosition,
+			SnapshotID:   addSnapshotID,
+		})
+	}
+
+	// Only then remove it from the playlist it is playing from, referring to it as it was saved
+	// before any relinking
+	removeURI := fmt.Sprintf("spotify:track:%s", items[position].Track.OriginalID())
+	tracks := []utils.PlaylistTrackRef{{URI: removeURI, Positions: []int{position}}}
+	removeSnapshotID, err := utils.RemoveTracksFromPlaylist(userAuthData.AccessToken, sourcePlaylistID, tracks, sourcePlaylist.SnapshotID)
+	if err != nil {
+		logger.Error("Failed to remove track", "error", err)
+
+		// Roll back the add so the song doesn't end up in both playlists
+		if len(action.Changes) > 0 {
+			rollback := utils.PlaylistAction{Type: utils.ActionAdd, Changes: action.Changes}
+			if _, err := utils.UndoAction(userAuthData.AccessToken, rollback); err != nil {
+				logger.Warn("Failed to roll back the add", "error", err)
+			}
+		}
+		http.Error(w, fmt.Sprintf("Error removing song from %s, so it was not moved", sourcePlaylistName), http.StatusInternalServerError)
+		return
+	}
+	action.Changes = append(action.Changes, utils.PlaylistChange{
+		Type:         utils.ActionRemove,
+		TrackURI:     removeURI,
+		TrackName:    songName,
+		PlaylistID:   sourcePlaylistID,
+		PlaylistName: sourcePlaylistName,
+		Position:     position,
+		SnapshotID:   removeSnapshotID,
+	})
+
+	err = utils.PushAction(userAuthData.UserID, action, redisPool.Get())
+	if err != nil {
+		logger.Warn("Failed to save action for undo", "error", err)
+	}
+
+	addResult := "added"
+	if isInPlaylist {
+		addResult = "duplicate"
+	}
+	history := []utils.HistoryEvent{
+		{
+			Type:         utils.HistoryAdd,
+			Track:        trackURI,
+			TrackName:    songName,
+			PlaylistID:   destinationPlaylistID,
+			PlaylistName: destinationPlaylistName,
+			Result:       addResult,
+			Source:       utils.KeyLabel(apiKey),
+		},
+		{
+			Type:         utils.HistoryRemove,
+			Track:        removeURI,
+			TrackName:    songName,
+			PlaylistID:   sourcePlaylistID,
+			PlaylistName: sourcePlaylistName,
+			Result:       "moved",
+			Source:       utils.KeyLabel(apiKey),
+		},
+	}
+	if requestBody.Skip {
+		history = append(history, skipHistory(logger, userAuthData.AccessToken, trackURI, songName, utils.KeyLabel(apiKey)))
+	}
+	recordHistory(logger, userAuthData.UserID, history, redisPool)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(settings.Message(utils.MessageMoved, fmt.Sprintf("Moved %s from %s to %s", songName, sourcePlaylistName, destinationPlaylistName))))
+}
@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMoveSongHandler_MissingPlaylist(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/move-song", strings.NewReader(`{"skip": true}`))
+	req.Header.Set("X-API-Key", "test-key")
+	recorder := httptest.NewRecorder()
+
+	MoveSongHandler(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"siri-playlist-actions/utils"
+	"strconv"
+)
+
+// Number of plays returned, unless 'limit' says otherwise
+const defaultPlaysLimit = 20
+
+// Maximum number of plays returned
+const maxPlaysLimit = 500
+
+// Handler for /api/plays, which searches the user's listening archive, newest first
+//
+//	from, to   limit the time range, as RFC 3339 times or dates in the user's time zone
+//	artist     keeps plays by artists whose name contains it
+//	limit      is the number of plays returned, at most 500
+func PlaysHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("plays", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "plays")
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		http.Error(w, "Missing API Key", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	limit := defaultPlaysLimit
+	if value := params.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPlaysLimit {
+			http.Error(w, fmt.Sprintf("Invalid 'limit': use a number from 1 to %d", maxPlaysLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+		return
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	settings, err := utils.GetUserSettings(userAuthData.UserID, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to load settings", "error", err)
+		http.Error(w, "Error retrieving your settings", http.StatusInternalServerError)
+		return
+	}
+	from, err := parseHistoryTime(params.Get("from"), settings.Location(), false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'from': %s", err), http.StatusBadRequest)
+		return
+	}
+	to, err := parseHistoryTime(params.Get("to"), settings.Location(), true)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'to': %s", err), http.StatusBadRequest)
+		return
+	}
+
+	// Filtering by artist happens after reading, so only an unfiltered read can stop at the limit
+	artist := params.Get("artist")
+	readLimit := limit
+	if artist != "" {
+		readLimit = 0
+	}
+	plays, err := utils.GetPlays(userAuthData.UserID, from, to, readLimit, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to get plays", "error", err)
+		http.Error(w, "Error retrieving your listening archive", http.StatusInternalServerError)
+		return
+	}
+	if artist != "" {
+		plays = utils.FilterPlaysByArtist(plays, artist)
+	}
+	if len(plays) > limit {
+		plays = plays[:limit]
+	}
+
+	message := "You didn't listen to anything that matches"
+	if len(plays) > 0 {
+		playedAt := plays[0].PlayedAt.In(settings.Location())
+		message = fmt.Sprintf("You heard %s on %s", plays[0].Describe(), playedAt.Format("Monday at 3:04 PM"))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"plays":   plays,
+	})
+}
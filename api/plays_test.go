@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPlaysHandler_MissingAPIKey(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/plays", nil)
+	recorder := httptest.NewRecorder()
+
+	PlaysHandler(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
+
+func TestBackfillPlaysHandler_RequiresCronSecret(t *testing.T) {
+	t.Setenv("CRON_SECRET", "secret")
+
+	for _, authorization := range []string{"", "Bearer wrong"} {
+		req := httptest.NewRequest("GET", "/api/backfill-plays", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+
+		BackfillPlaysHandler(recorder, req)
+
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d, got %d", http.StatusUnauthorized, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"siri-playlist-actions/utils"
+)
+
+// ReadyzHandler reports whether requests can be served: Redis must answer PING. Anyone can call
+// it, so the deep check, where Redis must also answer a write followed by a read and Spotify's
+// accounts and API hosts must be reachable, needs METRICS_TOKEN as a bearer token. Each
+// dependency's status and latency is listed, and the response is a 503 if any of them failed
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackProbe("readyz", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "readyz")
+
+	metricsToken := os.Getenv("METRICS_TOKEN")
+	deep := metricsToken != "" && utils.HasBearerToken(r, metricsToken)
+	checks, ready := utils.CheckReadiness(logger, deep)
+
+	status := "ok"
+	statusCode := http.StatusOK
+	if !ready {
+		status = "error"
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
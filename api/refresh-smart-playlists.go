@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"siri-playlist-actions/utils"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Number of history events read per request while generating smart playlists
+const smartHistoryPageSize = 1000
+
+// Most users a refresh handles, so a run finishes within the function timeout however many
+// users there are. The rest are handled by the following runs
+const smartRefreshUsersPerRun = 25
+
+// SmartPlaylistResult reports what a refresh did to one smart playlist
+type SmartPlaylistResult struct {
+	Name       string `json:"name"`
+	PlaylistID string `json:"playlist_id,omitempty"`
+	// Status is "created", "updated", "unchanged" or "error"
+	Status string `json:"status"`
+	Tracks int    `json:"tracks"`
+}
+
+// RefreshSmartPlaylistsHandler is run by Vercel Cron after the plays backfill. For the next batch
+// of users it regenerates their smart playlists, creating the Spotify playlists the first time
+// and only rewriting those whose tracks changed
+func RefreshSmartPlaylistsHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("refresh-smart-playlists", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "refresh-smart-playlists")
+
+	// Vercel Cron sends the CRON_SECRET environment variable as a bearer token
+	cronSecret := os.Getenv("CRON_SECRET")
+	if cronSecret == "" || r.Header.Get("Authorization") != "Bearer "+cronSecret {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	userIDs, err := utils.NextUserBatch("refresh-smart-playlists", smartRefreshUsersPerRun, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to list users", "error", err)
+		http.Error(w, "Error listing users", http.StatusInternalServerError)
+		return
+	}
+
+	// One user's failure, e.g. a revoked token, shouldn't stop the others
+	counts := map[string]int{"users": len(userIDs)}
+	for _, userID := range userIDs {
+		results, err := refreshSmartPlaylists(logger, userID, redisPool, time.Now())
+		if err != nil {
+			logger.Error("Failed to refresh smart playlists", "user_id", userID, "error", err)
+			counts["failed"]++
+			continue
+		}
+		for _, result := range results {
+			counts[result.Status]++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(counts)
+}
+
+// Regenerates a user's smart playlists. A playlist that fails is reported as an error and
+// doesn't stop the others
+func refreshSmartPlaylists(logger *slog.Logger, userID string, redisPool *redis.Pool, now time.Time) ([]SmartPlaylistResult, error) {
+	settings, err := utils.GetUserSettings(userID, redisPool.Get())
+	if err != nil {
+		return nil, err
+	}
+	if len(settings.SmartPlaylists) == 0 {
+		return nil, nil
+	}
+
+	apiKey, err := utils.GetUserIDToAPIKey(userID, redisPool.Get())
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("no API key")
+	}
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	// Read the archive and the history once for all of the user's smart playlists
+	location := settings.Location()
+	var plays []utils.Play
+	var events []utils.HistoryEvent
+	historyFrom := time.Time{}
+	for _, smart := range settings.SmartPlaylists {
+		if !smart.NeedsHistory() {
+			if plays == nil {
+				plays, err = utils.GetPlays(userID, time.Time{}, time.Time{}, 0, redisPool.Get())
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if start := smart.Start(now, location); historyFrom.IsZero() || start.Before(historyFrom) {
+			historyFrom = start
+		}
+	}
+	if !historyFrom.IsZero() {
+		events, err = getHistorySince(userID, historyFrom, redisPool)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	playlistIDs, err := utils.GetSmartPlaylistIDs(userID, redisPool.Get())
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SmartPlaylistResult
+	for _, smart := range settings.SmartPlaylists {
+		uris := utils.SmartPlaylistTracks(smart, plays, events, now, location)
+		result := SmartPlaylistResult{Name: smart.Name, PlaylistID: playlistIDs[smart.Name], Tracks: len(uris)}
+
+		// Recreate the playlist if it was never created or has been deleted. Spotify keeps serving
+		// a deleted playlist, so check the user still follows it
+		if result.PlaylistID != "" {
+			following, err := utils.IsFollowingPlaylist(userAuthData.AccessToken, result.PlaylistID, userID)
+			if err != nil && err != utils.ErrPlaylistNotFound {
+				logger.Error("Failed to check smart playlist", "playlist", smart.Name, "error", err)
+				result.Status = "error"
+				results = append(results, result)
+				continue
+			}
+			if !following {
+				result.PlaylistID = ""
+			}
+		}
+		created := false
+		if result.PlaylistID == "" {
+			playlist, err := utils.CreatePlaylist(userAuthData.AccessToken, userID, smart.Name, smart.Describe(), false)
+			if err != nil {
+				logger.Error("Failed to create smart playlist", "playlist", smart.Name, "error", err)
+				result.Status = "error"
+				results = append(results, result)
+				continue
+			}
+			result.PlaylistID = playlist.ID
+			created = true
+			err = utils.SetSmartPlaylistID(userID, smart.Name, playlist.ID, redisPool.Get())
+			if err != nil {
+				logger.Warn("Failed to save smart playlist ID", "error", err)
+			}
+		}
+
+		changed, err := utils.SyncPlaylistTracks(userAuthData.AccessToken, result.PlaylistID, uris)
+		switch {
+		case err != nil:
+			logger.Error("Failed to update smart playlist", "playlist", smart.Name, "error", err)
+			result.Status = "error"
+		case created:
+			result.Status = "created"
+		case changed:
+			result.Status = "updated"
+		default:
+			result.Status = "unchanged"
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Reads every history event since from, newest first
+func getHistorySince(userID string, from time.Time, redisPool *redis.Pool) ([]utils.HistoryEvent, error) {
+	var events []utils.HistoryEvent
+	query := utils.HistoryQuery{From: from, Limit: smartHistoryPageSize}
+	for {
+		page, nextCursor, err := utils.GetHistory(userID, query, redisPool.Get())
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, page...)
+		if nextCursor == "" {
+			return events, nil
+		}
+		query.Cursor = nextCursor
+	}
+}
@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefreshSmartPlaylistsHandler_RequiresCronSecret(t *testing.T) {
+	t.Setenv("CRON_SECRET", "")
+
+	req := httptest.NewRequest("GET", "/api/refresh-smart-playlists", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	recorder := httptest.NewRecorder()
+
+	RefreshSmartPlaylistsHandler(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
@@ -1,13 +1,33 @@
 package handler
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"siri-playlist-actions/utils"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
 )
 
+// RemoveRequestBody defines the optional JSON payload for /api/remove-song
+type RemoveRequestBody struct {
+	// AllOccurrences removes every copy of the song instead of only the one playing
+	AllOccurrences bool `json:"all_occurrences"`
+	// Fork copies a playlist the user can't edit into their account, without the song
+	Fork bool `json:"fork"`
+	// Skip overrides the user's skip_after_remove setting
+	Skip *bool `json:"skip"`
+}
+
 // RemoveSongHandler removes the currently playing song from the playlist
 func RemoveSongHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("remove-song", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "remove-song")
+
 	// Get the API Key from request header
 	apiKey := r.Header.Get("X-API-Key")
 	if apiKey == "" {
@@ -15,6 +35,14 @@ func RemoveSongHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Parse optional JSON request body
+	var requestBody RemoveRequestBody
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
 	// connect to database
 	redisPool, err := utils.InitRedis()
 	if err != nil {
@@ -26,57 +54,299 @@ func RemoveSongHandler(w http.ResponseWriter, r *http.Request) {
 	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
 		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
 	}
-	userAuthData, err := utils.GetAPIKeyToUserAuthData(apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
 	if err != nil {
 		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
 		return
 	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	settings, err := utils.GetUserSettings(userAuthData.UserID, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to load settings", "error", err)
+		http.Error(w, "Error retrieving your settings", http.StatusInternalServerError)
+		return
+	}
+	skip := *settings.SkipAfterRemove
+	if requestBody.Skip != nil {
+		skip = *requestBody.Skip
+	}
 
 	// Get currently playing song
-	songID, _, _, playlistID, _, err := utils.GetCurrentlyPlayingSong(userAuthData.AccessToken)
+	playing, err := utils.GetCurrentlyPlaying(userAuthData.AccessToken)
 	if err != nil {
-		log.Print(err)
+		logger.Error("Failed to get the currently playing song", "error", err)
 		http.Error(w, "Error retrieving currently playing song", http.StatusInternalServerError)
 		return
 	}
 
-	if songID == "" {
+	if playing == nil {
 		http.Error(w, "No song is currently playing", http.StatusNotFound)
 		return
 	}
+	songID, songName := playing.Track.ID, playing.Track.Name
+	playlistID, playlistName := playing.PlaylistID, playing.PlaylistName
+
+	if playing.IsLikedSongs() {
+		removeFromLikedSongs(logger, w, userAuthData, settings, songID, songName, skip, utils.KeyLabel(apiKey), redisPool)
+		return
+	}
 
 	if playlistID == "" {
-		http.Error(w, "The song is not playing from a playlist, so it cannot be removed", http.StatusNotFound)
+		http.Error(w, "The song is not playing from a playlist or your Liked Songs, so it cannot be removed", http.StatusNotFound)
+		return
+	}
+
+	// Check if the user may edit the playlist
+	permission, playlist, err := utils.GetPlaylistPermission(userAuthData.AccessToken, playlistID, userAuthData.UserID)
+	if err != nil {
+		logger.Error("Failed to check playlist permission", "error", err)
+		http.Error(w, "Error checking playlist permissions", http.StatusInternalServerError)
 		return
 	}
+	switch permission {
+	case utils.PlaylistNotFound:
+		http.Error(w, "The current playlist could not be found", http.StatusNotFound)
+		return
+	}
+
+	// Changes to a playlist the user has forked go to their fork instead
+	forked := false
+	if permission == utils.PlaylistReadOnly {
+		forkID, err := utils.GetPlaylistFork(userAuthData.UserID, playlistID, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to look up playlist fork", "error", err)
+			http.Error(w, "Error retrieving your playlist copies", http.StatusInternalServerError)
+			return
+		}
+
+		if forkID != "" {
+			fork, err := utils.GetPlaylist(userAuthData.AccessToken, forkID)
+			if err != nil && err != utils.ErrPlaylistNotFound {
+				logger.Error("Failed to get playlist", "error", err)
+				http.Error(w, "Error retrieving your copy of the playlist", http.StatusInternalServerError)
+				return
+			}
+			if err == nil {
+				playlistID, playlist, forked = forkID, fork, true
+			}
+		}
+
+		if !forked {
+			if !requestBody.Fork {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("The current playlist is read-only for you, so we cannot remove this song"))
+				return
+			}
 
-	// Check if the user owns the playlist
-	isOwner, err := utils.IsPlaylistOwnedByUser(userAuthData.AccessToken, playlistID)
+			forkWithoutSong(logger, w, userAuthData, settings, playlist, songID, songName, utils.KeyLabel(apiKey), redisPool)
+			return
+		}
+	}
+
+	// Find where the song is, pinned to the version of the playlist we just checked
+	items, err := utils.GetPlaylistItems(userAuthData.AccessToken, playlistID)
 	if err != nil {
-		log.Print(err)
-		http.Error(w, "Error checking playlist ownership. Do you own this playlist?", http.StatusInternalServerError)
+		logger.Error("Failed to get playlist items", "error", err)
+		http.Error(w, "Error retrieving playlist tracks", http.StatusInternalServerError)
 		return
 	}
-	if !isOwner {
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte("The current playlist is not owned by you, so we cannot remove this song"))
+
+	positions := utils.TrackPositions(items, songID)
+	if len(positions) > 1 && !requestBody.AllOccurrences {
+		// The next track in the queue tells us which copy is playing
+		nextTrackID := ""
+		queue, err := utils.GetQueue(userAuthData.AccessToken)
+		if err != nil {
+			logger.Warn("Failed to get queue", "error", err)
+		} else if len(queue) > 0 {
+			nextTrackID = queue[0].ID
+		}
+		positions = []int{utils.FindPlayingPosition(items, songID, nextTrackID)}
+	}
+	if len(positions) == 0 {
+		http.Error(w, "The song could not be found in the playlist", http.StatusNotFound)
 		return
 	}
 
-	// Remove the song from the playlist
-	err = utils.RemoveSongFromPlaylist(userAuthData.AccessToken, playlistID, songID)
+	// Remove the song from the playlist, referring to it as it was saved before any relinking
+	trackURI := fmt.Sprintf("spotify:track:%s", items[positions[0]].Track.OriginalID())
+	tracks := []utils.PlaylistTrackRef{{URI: trackURI, Positions: positions}}
+	snapshotID, err := utils.RemoveTracksFromPlaylist(userAuthData.AccessToken, playlistID, tracks, playlist.SnapshotID)
 	if err != nil {
-		log.Print(err)
+		logger.Error("Failed to remove track", "error", err)
 		http.Error(w, "Error removing song from playlist", http.StatusInternalServerError)
 		return
 	}
-	err = utils.SkipSong(userAuthData.AccessToken)
-	if err != nil {
-		log.Printf("Failed to skip song with error: %s", err)
-		// continue
+
+	action := utils.PlaylistAction{Type: utils.ActionRemove, CreatedAt: time.Now()}
+	for _, position := range positions {
+		action.Changes = append(action.Changes, utils.PlaylistChange{
+			TrackURI:     trackURI,
+			TrackName:    songName,
+			PlaylistID:   playlistID,
+			PlaylistName: playlistName,
+			Position:     position,
+			SnapshotID:   snapshotID,
+		})
+	}
+	if len(action.Changes) > 0 {
+		err = utils.PushAction(userAuthData.UserID, action, redisPool.Get())
+		if err != nil {
+			logger.Warn("Failed to save action for undo", "error", err)
+		}
+	}
+	history := []utils.HistoryEvent{{
+		Type:         utils.HistoryRemove,
+		Track:        trackURI,
+		TrackName:    songName,
+		PlaylistID:   playlistID,
+		PlaylistName: playlistName,
+		Result:       "removed",
+		Source:       utils.KeyLabel(apiKey),
+	}}
+	if forked {
+		// Continue from the user's copy at the track that followed the removed one
+		nextPosition := positions[0]
+		if nextPosition >= playlist.Tracks.Total-len(positions) {
+			nextPosition = 0
+		}
+		err = utils.StartPlayback(userAuthData.AccessToken, "spotify:playlist:"+playlistID, nextPosition)
+		if err != nil {
+			logger.Warn("Failed to start playback of fork", "error", err)
+		}
+	} else if skip {
+		history = append(history, skipHistory(logger, userAuthData.AccessToken, trackURI, songName, utils.KeyLabel(apiKey)))
 	}
+	recordHistory(logger, userAuthData.UserID, history, redisPool)
 
 	// Success response
+	message := fmt.Sprintf("Song removed from your playlist %s", playlistName)
+	if forked {
+		message = fmt.Sprintf("Song removed from your copy of %s", playlistName)
+	} else if len(positions) > 1 {
+		message = fmt.Sprintf("Removed %d copies of the song from your playlist %s", len(positions), playlistName)
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(settings.Message(utils.MessageRemoved, message)))
+}
+
+// Removes the song from the user's Liked Songs, then skips it if asked to
+func removeFromLikedSongs(logger *slog.Logger, w http.ResponseWriter, userAuthData *utils.UserAuthData, settings utils.UserSettings, songID, songName string, skip bool, source string, redisPool *redis.Pool) {
+	err := utils.RemoveSavedTracks(userAuthData.AccessToken, []string{songID})
+	if err != nil {
+		logger.Error("Failed to remove from Liked Songs", "error", err)
+		http.Error(w, "Error removing song from your Liked Songs", http.StatusInternalServerError)
+		return
+	}
+
+	action := utils.PlaylistAction{Type: utils.ActionRemove, CreatedAt: time.Now(), Changes: []utils.PlaylistChange{{
+		TrackURI:     fmt.Sprintf("spotify:track:%s", songID),
+		TrackName:    songName,
+		PlaylistID:   utils.LikedSongsPlaylistID,
+		PlaylistName: "Liked Songs",
+		Position:     0,
+	}}}
+	err = utils.PushAction(userAuthData.UserID, action, redisPool.Get())
+	if err != nil {
+		logger.Warn("Failed to save action for undo", "error", err)
+	}
+
+	history := []utils.HistoryEvent{{
+		Type:      utils.HistoryLike,
+		Track:     action.Changes[0].TrackURI,
+		TrackName: songName,
+		Result:    "unliked",
+		Source:    source,
+	}}
+	if skip {
+		history = append(history, skipHistory(logger, userAuthData.AccessToken, action.Changes[0].TrackURI, songName, source))
+	}
+	recordHistory(logger, userAuthData.UserID, history, redisPool)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(settings.Message(utils.MessageRemoved, "Song removed from your Liked Songs")))
+}
+
+// Copies a playlist the user can't edit into their account without the playing song, then
+// continues playback from the copy
+func forkWithoutSong(logger *slog.Logger, w http.ResponseWriter, userAuthData *utils.UserAuthData, settings utils.UserSettings, source *utils.Playlist, songID, songName, keyLabel string, redisPool *redis.Pool) {
+	items, err := utils.GetPlaylistItems(userAuthData.AccessToken, source.ID)
+	if err != nil {
+		logger.Error("Failed to get playlist items", "error", err)
+		http.Error(w, "Error retrieving playlist tracks", http.StatusInternalServerError)
+		return
+	}
+
+	nextTrackID := ""
+	queue, err := utils.GetQueue(userAuthData.AccessToken)
+	if err != nil {
+		logger.Warn("Failed to get queue", "error", err)
+	} else if len(queue) > 0 {
+		nextTrackID = queue[0].ID
+	}
+	position := utils.FindPlayingPosition(items, songID, nextTrackID)
+	if position < 0 {
+		http.Error(w, "The song could not be found in the playlist", http.StatusNotFound)
+		return
+	}
+
+	fork, nextPosition, err := utils.ForkPlaylist(userAuthData.AccessToken, userAuthData.UserID, source, items, position)
+	if err != nil {
+		logger.Error("Failed to fork playlist", "error", err)
+		http.Error(w, "Error copying the playlist", http.StatusInternalServerError)
+		return
+	}
+
+	// Later removals from the original go to the copy
+	err = utils.SetPlaylistFork(userAuthData.UserID, source.ID, fork.ID, redisPool.Get())
+	if err != nil {
+		logger.Warn("Failed to save playlist fork", "error", err)
+	}
+
+	// Undoing puts the song back into the copy where it would have been
+	action := utils.PlaylistAction{Type: utils.ActionRemove, CreatedAt: time.Now(), Changes: []utils.PlaylistChange{{
+		TrackURI:     fmt.Sprintf("spotify:track:%s", songID),
+		TrackName:    songName,
+		PlaylistID:   fork.ID,
+		PlaylistName: fork.Name,
+		Position:     nextPosition,
+		SnapshotID:   fork.SnapshotID,
+	}}}
+	err = utils.PushAction(userAuthData.UserID, action, redisPool.Get())
+	if err != nil {
+		logger.Warn("Failed to save action for undo", "error", err)
+	}
+
+	recordHistory(logger, userAuthData.UserID, []utils.HistoryEvent{{
+		Type:         utils.HistoryRemove,
+		Track:        action.Changes[0].TrackURI,
+		TrackName:    songName,
+		PlaylistID:   source.ID,
+		PlaylistName: source.Name,
+		Result:       "forked",
+		Source:       keyLabel,
+	}}, redisPool)
+
+	if nextPosition >= fork.Tracks.Total {
+		nextPosition = 0
+	}
+	err = utils.StartPlayback(userAuthData.AccessToken, "spotify:playlist:"+fork.ID, nextPosition)
+	if err != nil {
+		logger.Warn("Failed to start playback of fork", "error", err)
+	}
+
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Song removed"))
+	w.Write([]byte(settings.Message(utils.MessageRemoved, fmt.Sprintf("Made your own copy of %s without this song", source.Name))))
+}
+
+// Skips to the next song, returning the history event for it
+func skipHistory(logger *slog.Logger, accessToken, trackURI, songName, source string) utils.HistoryEvent {
+	event := utils.HistoryEvent{Type: utils.HistorySkip, Track: trackURI, TrackName: songName, Result: "skipped", Source: source}
+	err := utils.SkipSong(accessToken)
+	if err != nil {
+		logger.Error("Failed to skip song", "error", err)
+		event.Result = "error"
+	}
+	return event
 }
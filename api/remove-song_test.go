@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRemoveSongHandler_MissingAPIKey(t *testing.T) {
+	req := httptest.NewRequest("DELETE", "/api/remove-song", nil)
+	recorder := httptest.NewRecorder()
+
+	RemoveSongHandler(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
+
+func TestRemoveSongHandler_InvalidJSON(t *testing.T) {
+	req := httptest.NewRequest("DELETE", "/api/remove-song", strings.NewReader(`{"all_occurrences": "yes"`))
+	req.Header.Set("X-API-Key", "test-key")
+	recorder := httptest.NewRecorder()
+
+	RemoveSongHandler(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
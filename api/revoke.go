@@ -1,13 +1,16 @@
 package handler
 
 import (
-	"fmt"
 	"net/http"
 	"siri-playlist-actions/utils"
 )
 
 // RevokeHandler removes the user's session from Redis
 func RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("revoke", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "revoke")
+
 	apiKey := r.Header.Get("X-API-Key")
 	if apiKey == "" {
 		http.Error(w, "Missing API Key", http.StatusUnauthorized)
@@ -25,23 +28,26 @@ func RevokeHandler(w http.ResponseWriter, r *http.Request) {
 	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
 		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
 	}
-	userAuthData, err := utils.GetAPIKeyToUserAuthData(apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
 	if err != nil {
 		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
 		return
 	}
+	logger = logger.With("user_id", userAuthData.UserID)
 
 	// Delete the API key from Redis
 	err = utils.DeleteAPIKey(apiKey, redisPool.Get())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error revoking session: %s", err), http.StatusInternalServerError)
+		logger.Error("Failed to delete API key", "error", err)
+		http.Error(w, "Error revoking session", http.StatusInternalServerError)
 		return
 	}
 
 	// Remove the user-to-API key mapping
 	err = utils.DeleteUserID(userAuthData.UserID, redisPool.Get())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error removing user session: %s", err), http.StatusInternalServerError)
+		logger.Error("Failed to delete user ID", "error", err)
+		http.Error(w, "Error removing user session", http.StatusInternalServerError)
 		return
 	}
 
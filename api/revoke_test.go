@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRevokeHandler_MissingAPIKey(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/revoke", nil)
+	req.Header.Set("X-Vercel-Id", "cdg1::abc-123")
+	recorder := httptest.NewRecorder()
+
+	RevokeHandler(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+	if got := recorder.Header().Get("X-Request-Id"); got != "cdg1::abc-123" {
+		t.Errorf("expected request ID %q, got %q", "cdg1::abc-123", got)
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"siri-playlist-actions/utils"
+)
+
+// Handler for /api/settings
+//
+//	GET returns the user's settings
+//	PUT updates the settings present in the JSON body and leaves the rest unchanged
+func SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("settings", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "settings")
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		http.Error(w, "Missing API Key", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+		return
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	settings, err := utils.GetUserSettings(userAuthData.UserID, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to load settings", "error", err)
+		http.Error(w, "Error retrieving settings", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		// Decoding onto the current settings only overwrites the fields in the body
+		err = json.NewDecoder(r.Body).Decode(&settings)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON body: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = settings.Validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		settings = settings.WithDefaults()
+
+		err = utils.SetUserSettings(userAuthData.UserID, settings, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to save settings", "error", err)
+			http.Error(w, "Error saving settings", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
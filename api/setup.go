@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"fmt"
 	"net/http"
 	"siri-playlist-actions/utils"
 	"text/template"
@@ -9,11 +8,17 @@ import (
 
 // Handler for /api/setup
 func SetupHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("setup", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "setup")
+
 	apiKey := r.URL.Query().Get("api_key")
 	if apiKey == "" {
 		http.Error(w, "API key not found", http.StatusBadRequest)
 		return
 	}
+	// The API key comes in the query rather than the X-API-Key header here
+	logger = logger.With("api_key_hash", utils.HashAPIKey(apiKey))
 
 	// Connect to Redis
 	redisPool, err := utils.InitRedis()
@@ -26,15 +31,17 @@ func SetupHandler(w http.ResponseWriter, r *http.Request) {
 	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
 		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
 	}
-	userAuthData, err := utils.GetAPIKeyToUserAuthData(apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid API Key: %s", err), http.StatusUnauthorized)
+		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
 		return
 	}
+	logger = logger.With("user_id", userAuthData.UserID)
 
 	// Fetch currently playing song
 	_, songName, artistName, playlistID, playlistName, err := utils.GetCurrentlyPlayingSong(userAuthData.AccessToken)
 	if err != nil {
+		logger.Warn("Failed to get currently playing song", "error", err)
 		songName, artistName, playlistName, playlistID = "Not Available", "Not Available", "Not Available", "Not Available"
 	}
 
@@ -127,7 +134,7 @@ func SetupHandler(w http.ResponseWriter, r *http.Request) {
 			<img class="example-img" src="/static/add-song.png" alt="Add Song example">
 
 			<h3>Shortcut 2: Remove song from the current playlist</h3>
-			<p>This shortcut allows you to remove a song from the currently playing playlist via a Siri voice command. This only works on playlists that you created.</p>
+			<p>This shortcut allows you to remove a song from the currently playing playlist via a Siri voice command. This works on playlists that you created and on collaborative playlists.</p>
 			<ol>
 				<li>Open the Shortcuts app on your iPhone or macbook (setting the shortcut up on one will mirror to the other). These instructions assume iPhone.</li>
 				<li>Tap "+" in the upper right.</li>
@@ -178,7 +185,8 @@ func SetupHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse and execute the template
 	t, err := template.New("setup").Parse(tmpl)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing template: %s", err), http.StatusInternalServerError)
+		logger.Error("Failed to parse template", "error", err)
+		http.Error(w, "Error parsing template", http.StatusInternalServerError)
 		return
 	}
 
@@ -191,6 +199,7 @@ func SetupHandler(w http.ResponseWriter, r *http.Request) {
 		"PlaylistID":   playlistID,
 	})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error rendering template: %s", err), http.StatusInternalServerError)
+		logger.Error("Failed to render template", "error", err)
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
 	}
 }
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"siri-playlist-actions/utils"
+)
+
+// TestWebhookRequestBody picks the webhook to test
+type TestWebhookRequestBody struct {
+	ID string `json:"id"`
+}
+
+// Handler for /api/test-webhook, which sends a test event to one of the user's webhooks right
+// away and reports the delivery. Test events are not retried
+func TestWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("test-webhook", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "test-webhook")
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		http.Error(w, "Missing API Key", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody TestWebhookRequestBody
+	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if requestBody.ID == "" {
+		http.Error(w, "Missing 'id'", http.StatusBadRequest)
+		return
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+		return
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	webhooks, err := utils.GetWebhooks(userAuthData.UserID, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to load webhooks", "error", err)
+		http.Error(w, "Error retrieving webhooks", http.StatusInternalServerError)
+		return
+	}
+	var webhook *utils.Webhook
+	for i := range webhooks {
+		if webhooks[i].ID == requestBody.ID {
+			webhook = &webhooks[i]
+		}
+	}
+	if webhook == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	event, err := utils.NewWebhookEvent(utils.HistoryEvent{Type: utils.WebhookTest})
+	if err != nil {
+		logger.Error("Failed to create webhook event", "error", err)
+		http.Error(w, "Error creating test event", http.StatusInternalServerError)
+		return
+	}
+	job := utils.WebhookJob{UserID: userAuthData.UserID, WebhookID: webhook.ID, Event: event}
+	delivery := runWebhookJob(logger, job, *webhook, redisPool)
+
+	w.Header().Set("Content-Type", "application/json")
+	if delivery.Status != "delivered" {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(delivery)
+}
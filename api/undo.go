@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"siri-playlist-actions/utils"
+)
+
+// UndoHandler reverses the most recent add or remove action
+func UndoHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("undo", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "undo")
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		http.Error(w, "Missing API Key", http.StatusUnauthorized)
+		return
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+		return
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	settings, err := utils.GetUserSettings(userAuthData.UserID, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to load settings", "error", err)
+		http.Error(w, "Error retrieving your settings", http.StatusInternalServerError)
+		return
+	}
+
+	action, err := utils.PopAction(userAuthData.UserID, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to load last action", "error", err)
+		http.Error(w, "Error retrieving your last action", http.StatusInternalServerError)
+		return
+	}
+	if action == nil {
+		http.Error(w, "There is nothing to undo", http.StatusNotFound)
+		return
+	}
+
+	remaining, err := utils.UndoAction(userAuthData.AccessToken, *action)
+	recordHistory(logger, userAuthData.UserID, undoHistory(*action, remaining, utils.KeyLabel(apiKey)), redisPool)
+	if err != nil {
+		logger.Error("Failed to undo action", "error", err)
+
+		// Keep what wasn't reversed so the user can try again without repeating the rest
+		retry := *action
+		retry.Changes = remaining
+		if err := utils.PushAction(userAuthData.UserID, retry, redisPool.Get()); err != nil {
+			logger.Warn("Failed to restore action for another undo", "error", err)
+		}
+		http.Error(w, fmt.Sprintf("Error undoing %s", action.Describe()), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(settings.Message(utils.MessageUndone, fmt.Sprintf("Undid %s", action.Describe()))))
+}
+
+// Builds a history event for every change the undo reversed, or failed to. remaining are the
+// changes that weren't reversed, in their original order
+func undoHistory(action utils.PlaylistAction, remaining []utils.PlaylistChange, source string) []utils.HistoryEvent {
+	var events []utils.HistoryEvent
+	for _, change := range action.Changes {
+		changeType := change.Type
+		if changeType == "" {
+			changeType = action.Type
+		}
+
+		result := "undid " + changeType
+		if len(remaining) > 0 && remaining[0] == change {
+			result = "error"
+			remaining = remaining[1:]
+		}
+		events = append(events, utils.HistoryEvent{
+			Type:         utils.HistoryUndo,
+			Track:        change.TrackURI,
+			TrackName:    change.TrackName,
+			PlaylistID:   change.PlaylistID,
+			PlaylistName: change.PlaylistName,
+			Result:       result,
+			Source:       source,
+		})
+	}
+	return events
+}
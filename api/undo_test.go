@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"siri-playlist-actions/utils"
+	"testing"
+)
+
+func TestUndoHandler_MissingAPIKey(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/undo", nil)
+	recorder := httptest.NewRecorder()
+
+	UndoHandler(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
+
+func TestUndoHistory_PartialFailure(t *testing.T) {
+	action := utils.PlaylistAction{Type: utils.ActionRemove, Changes: []utils.PlaylistChange{
+		{TrackURI: "spotify:track:a", PlaylistID: "1", Position: 3},
+		{TrackURI: "spotify:track:a", PlaylistID: "2", Position: 5},
+	}}
+
+	events := undoHistory(action, action.Changes[1:], "key 3f2a9c1d")
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Result != "undid remove" {
+		t.Errorf("expected the first change to be undone, got %q", events[0].Result)
+	}
+	if events[1].Result != "error" {
+		t.Errorf("expected the second change to fail, got %q", events[1].Result)
+	}
+}
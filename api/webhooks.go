@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"siri-playlist-actions/utils"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// WebhookRequestBody creates a webhook
+type WebhookRequestBody struct {
+	URL string `json:"url"`
+	// Events lists the event types to send: "add", "remove", "like", "skip" or "undo". Empty means
+	// all
+	Events []string `json:"events,omitempty"`
+	// Format is "json" (the default) or "discord"
+	Format string `json:"format,omitempty"`
+}
+
+// Handler for /api/webhooks
+//
+//	GET lists the user's webhooks, without their secrets, and the latest deliveries
+//	POST creates a webhook from the JSON body and returns it with its secret
+//	DELETE removes the webhook whose ID is the 'id' query parameter
+func WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	w, done := utils.TrackRequest("webhooks", w)
+	defer done()
+	logger := utils.RequestLogger(w, r, "webhooks")
+
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		http.Error(w, "Missing API Key", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody WebhookRequestBody
+	var webhook utils.Webhook
+	if r.Method == http.MethodPost {
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid JSON body: %s", err), http.StatusBadRequest)
+			return
+		}
+		webhook, err = utils.NewWebhook(requestBody.URL, requestBody.Events, requestBody.Format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	webhookID := r.URL.Query().Get("id")
+	if r.Method == http.MethodDelete && webhookID == "" {
+		http.Error(w, "Missing 'id' query parameter", http.StatusBadRequest)
+		return
+	}
+
+	// connect to database
+	redisPool, err := utils.InitRedis()
+	if err != nil {
+		http.Error(w, "Error connecting to database", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Close()
+
+	setFn := func(apiKey string, token *utils.SpotifyAccessToken, userID string) error {
+		return utils.SetAPIKeyToUserAuthData(apiKey, token, userID, redisPool.Get())
+	}
+	userAuthData, err := utils.GetAPIKeyToUserAuthData(logger, apiKey, redisPool.Get(), utils.RefreshSpotifyToken, setFn)
+	if err != nil {
+		http.Error(w, "Invalid API Key", http.StatusUnauthorized)
+		return
+	}
+	logger = logger.With("user_id", userAuthData.UserID)
+
+	webhooks, err := utils.GetWebhooks(userAuthData.UserID, redisPool.Get())
+	if err != nil {
+		logger.Error("Failed to load webhooks", "error", err)
+		http.Error(w, "Error retrieving webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if len(webhooks) >= utils.MaxWebhooks {
+			http.Error(w, fmt.Sprintf("You already have %d webhooks. Delete one first", utils.MaxWebhooks), http.StatusBadRequest)
+			return
+		}
+		err = utils.SetWebhooks(userAuthData.UserID, append(webhooks, webhook), redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to save webhooks", "error", err)
+			http.Error(w, "Error saving webhook", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(webhook)
+
+	case http.MethodDelete:
+		remaining := []utils.Webhook{}
+		for _, webhook := range webhooks {
+			if webhook.ID != webhookID {
+				remaining = append(remaining, webhook)
+			}
+		}
+		if len(remaining) == len(webhooks) {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		err = utils.SetWebhooks(userAuthData.UserID, remaining, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to save webhooks", "error", err)
+			http.Error(w, "Error deleting webhook", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Deleted the webhook"))
+
+	default:
+		deliveries, err := utils.GetWebhookDeliveries(userAuthData.UserID, redisPool.Get())
+		if err != nil {
+			logger.Error("Failed to load webhook deliveries", "error", err)
+			http.Error(w, "Error retrieving webhook deliveries", http.StatusInternalServerError)
+			return
+		}
+		for i := range webhooks {
+			webhooks[i].Secret = ""
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"webhooks":   webhooks,
+			"deliveries": deliveries,
+		})
+	}
+}
+
+// Records events in the user's history and sends them to the user's webhooks. Failures are
+// logged, since the action itself has already happened
+func recordHistory(logger *slog.Logger, userID string, events []utils.HistoryEvent, redisPool *redis.Pool) {
+	err := utils.RecordHistory(userID, events, redisPool.Get())
+	if err != nil {
+		logger.Warn("Failed to record history", "error", err)
+	}
+	dispatchWebhooks(logger, userID, events, redisPool)
+}
+
+// Queues events for the user's webhooks. They are delivered by the deliver-webhooks cron job, so
+// slow webhooks don't hold up the response to Siri
+func dispatchWebhooks(logger *slog.Logger, userID string, events []utils.HistoryEvent, redisPool *redis.Pool) {
+	webhooks, err := utils.GetWebhooks(userID, redisPool.Get())
+	if err != nil {
+		logger.Warn("Failed to load webhooks", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, event := range events {
+		webhookEvent, err := utils.NewWebhookEvent(event)
+		if err != nil {
+			logger.Warn("Failed to create webhook event", "error", err)
+			continue
+		}
+		for _, webhook := range webhooks {
+			if !webhook.Wants(event.Type) {
+				continue
+			}
+			job := utils.WebhookJob{UserID: userID, WebhookID: webhook.ID, Event: webhookEvent}
+			err = utils.QueueWebhookJob(job, now, redisPool.Get())
+			if err != nil {
+				logger.Warn("Failed to queue webhook event", "webhook_id", webhook.ID, "error", err)
+			}
+		}
+	}
+}
+
+// Makes the next attempt at a job, queueing another if it fails and attempts remain, and logs
+// the delivery. Test events are attempted once
+func runWebhookJob(logger *slog.Logger, job utils.WebhookJob, webhook utils.Webhook, redisPool *redis.Pool) utils.WebhookDelivery {
+	job.Attempt++
+	statusCode, err := utils.DeliverWebhook(webhook, job.Event)
+
+	delivery := utils.WebhookDelivery{
+		EventID:    job.Event.ID,
+		EventType:  job.Event.Type,
+		WebhookID:  job.WebhookID,
+		Attempt:    job.Attempt,
+		Time:       time.Now().UTC(),
+		Status:     "delivered",
+		StatusCode: statusCode,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+		delivery.Status = "failed"
+		if job.Attempt < utils.MaxWebhookAttempts && job.Event.Type != utils.WebhookTest {
+			queueErr := utils.QueueWebhookJob(job, time.Now().Add(job.RetryDelay()), redisPool.Get())
+			if queueErr != nil {
+				logger.Warn("Failed to queue webhook retry", "error", queueErr)
+			} else {
+				delivery.Status = "retrying"
+			}
+		}
+	}
+
+	err = utils.LogWebhookDelivery(job.UserID, delivery, redisPool.Get())
+	if err != nil {
+		logger.Warn("Failed to log webhook delivery", "error", err)
+	}
+
+	return delivery
+}
+
+// Delivers up to limit due jobs, new events and retries alike, returning how many were
+// attempted. Jobs for deleted webhooks are dropped
+func processWebhookQueue(logger *slog.Logger, limit int, redisPool *redis.Pool) int {
+	jobs, err := utils.ClaimWebhookJobs(time.Now(), limit, redisPool.Get())
+	if err != nil {
+		logger.Warn("Failed to claim webhook jobs", "error", err)
+	}
+
+	var wg sync.WaitGroup
+	webhooksByUser := map[string][]utils.Webhook{}
+	attempted := 0
+	for _, job := range jobs {
+		logger := logger.With("user_id", job.UserID)
+		webhooks, ok := webhooksByUser[job.UserID]
+		if !ok {
+			webhooks, err = utils.GetWebhooks(job.UserID, redisPool.Get())
+			if err != nil {
+				logger.Warn("Failed to load webhooks", "error", err)
+				// Put the job back without counting an attempt
+				err = utils.QueueWebhookJob(job, time.Now().Add(job.RetryDelay()), redisPool.Get())
+				if err != nil {
+					logger.Warn("Failed to queue webhook retry", "error", err)
+				}
+				continue
+			}
+			webhooksByUser[job.UserID] = webhooks
+		}
+		for _, webhook := range webhooks {
+			if webhook.ID == job.WebhookID {
+				wg.Add(1)
+				go func(job utils.WebhookJob, webhook utils.Webhook) {
+					defer wg.Done()
+					runWebhookJob(logger, job, webhook, redisPool)
+				}(job, webhook)
+				attempted++
+				break
+			}
+		}
+	}
+	wg.Wait()
+
+	return attempted
+}
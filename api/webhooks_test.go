@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhooksHandler_MissingAPIKey(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/webhooks", nil)
+	recorder := httptest.NewRecorder()
+
+	WebhooksHandler(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
+
+func TestWebhooksHandler_ReturnsRequestID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/webhooks", nil)
+	req.Header.Set("X-Vercel-Id", "cdg1::abc-123")
+	recorder := httptest.NewRecorder()
+
+	WebhooksHandler(recorder, req)
+
+	if got := recorder.Header().Get("X-Request-Id"); got != "cdg1::abc-123" {
+		t.Errorf("expected request ID %q, got %q", "cdg1::abc-123", got)
+	}
+}
+
+func TestWebhooksHandler_InvalidURL(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(`{"url": "http://example.com/hook"}`))
+	req.Header.Set("X-API-Key", "test-api-key")
+	recorder := httptest.NewRecorder()
+
+	WebhooksHandler(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestTestWebhookHandler_MissingID(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/test-webhook", strings.NewReader(`{}`))
+	req.Header.Set("X-API-Key", "test-api-key")
+	recorder := httptest.NewRecorder()
+
+	TestWebhookHandler(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
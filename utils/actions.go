@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Types of actions that can be undone
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+	ActionMove   = "move"
+)
+
+// Stands in for a playlist ID when a change was made to the user's Liked Songs
+const LikedSongsPlaylistID = "collection"
+
+// Number of recent actions kept per user for undo
+const maxUndoActions = 20
+
+// PlaylistChange is a single track added to or removed from a playlist
+type PlaylistChange struct {
+	// Type is ActionAdd or ActionRemove. It defaults to the action's type when empty
+	Type         string `json:"type,omitempty"`
+	TrackURI     string `json:"track_uri"`
+	TrackName    string `json:"track_name"`
+	PlaylistID   string `json:"playlist_id"`
+	PlaylistName string `json:"playlist_name"`
+	// Position is where the track was inserted, or where it was before being removed
+	Position int `json:"position"`
+	// SnapshotID is the playlist version right after the change
+	SnapshotID string `json:"snapshot_id"`
+	// Rotation marks tracks rotated out of a full playlist, or into its archive. They are undone
+	// along with the rest of the action but left out of its description
+	Rotation bool `json:"rotation,omitempty"`
+}
+
+// PlaylistAction is everything a single voice command changed, so it can be undone as a whole
+type PlaylistAction struct {
+	Type      string           `json:"type"`
+	Changes   []PlaylistChange `json:"changes"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// Describes the action for speech, e.g. "adding Yellow to Gym and Running"
+func (a PlaylistAction) Describe() string {
+	var trackNames, playlistNames []string
+	seenTracks, seenPlaylists := map[string]bool{}, map[string]bool{}
+	for _, change := range a.Changes {
+		if change.Rotation {
+			continue
+		}
+		if !seenTracks[change.TrackURI] {
+			seenTracks[change.TrackURI] = true
+			trackNames = append(trackNames, change.TrackName)
+		}
+		if !seenPlaylists[change.PlaylistID] {
+			seenPlaylists[change.PlaylistID] = true
+			playlistNames = append(playlistNames, change.PlaylistName)
+		}
+	}
+
+	tracks := JoinNames(trackNames)
+	if len(trackNames) > 1 {
+		tracks = fmt.Sprintf("%d songs", len(trackNames))
+	}
+
+	if a.Type == ActionMove {
+		// Moves record the add to the destination, if any, before the removal from the source
+		if len(playlistNames) == 2 {
+			return fmt.Sprintf("moving %s from %s to %s", tracks, playlistNames[1], playlistNames[0])
+		}
+		return fmt.Sprintf("removing %s from %s", tracks, JoinNames(playlistNames))
+	}
+	if a.Type == ActionRemove {
+		return fmt.Sprintf("removing %s from %s", tracks, JoinNames(playlistNames))
+	}
+	return fmt.Sprintf("adding %s to %s", tracks, JoinNames(playlistNames))
+}
+
+// Joins names for speech, e.g. "A", "A and B", "A, B and C"
+func JoinNames(names []string) string {
+	if len(names) <= 1 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// Reverses an action: added tracks are removed again, and removed tracks are put back at their
+// original positions. If it fails partway, it also returns the changes it didn't reverse, so
+// that undoing again only retries those
+func UndoAction(accessToken string, action PlaylistAction) ([]PlaylistChange, error) {
+	var added, removed []int
+	for i, change := range action.Changes {
+		changeType := change.Type
+		if changeType == "" {
+			changeType = action.Type
+		}
+
+		switch changeType {
+		case ActionAdd:
+			added = append(added, i)
+		case ActionRemove:
+			removed = append(removed, i)
+		default:
+			return action.Changes, fmt.Errorf("cannot undo change of type %q", changeType)
+		}
+	}
+
+	undone := make([]bool, len(action.Changes))
+	remaining := func() []PlaylistChange {
+		var changes []PlaylistChange
+		for i, change := range action.Changes {
+			if !undone[i] {
+				changes = append(changes, change)
+			}
+		}
+		return changes
+	}
+
+	for _, group := range groupChangesByPlaylist(action.Changes, added) {
+		changes := selectChanges(action.Changes, group)
+		if changes[0].PlaylistID == LikedSongsPlaylistID {
+			if err := RemoveSavedTracks(accessToken, changeTrackIDs(changes)); err != nil {
+				return remaining(), err
+			}
+			markUndone(undone, group)
+			continue
+		}
+
+		// Remove exactly the added occurrences when we know where they went, pinned to the
+		// snapshot taken right after adding them
+		var tracks []PlaylistTrackRef
+		snapshotID := changes[0].SnapshotID
+		indexByURI := map[string]int{}
+		for _, change := range changes {
+			i, exists := indexByURI[change.TrackURI]
+			if !exists {
+				i = len(tracks)
+				indexByURI[change.TrackURI] = i
+				tracks = append(tracks, PlaylistTrackRef{URI: change.TrackURI})
+			}
+			if change.Position < 0 {
+				snapshotID = ""
+			} else {
+				tracks[i].Positions = append(tracks[i].Positions, change.Position)
+			}
+		}
+		if snapshotID == "" {
+			for i := range tracks {
+				tracks[i].Positions = nil
+			}
+		}
+
+		_, err := RemoveTracksFromPlaylist(accessToken, changes[0].PlaylistID, tracks, snapshotID)
+		if err != nil {
+			return remaining(), err
+		}
+		markUndone(undone, group)
+	}
+
+	for _, group := range groupChangesByPlaylist(action.Changes, removed) {
+		changes := selectChanges(action.Changes, group)
+		// Liked Songs are ordered by when they were saved, so there is no position to restore
+		if changes[0].PlaylistID == LikedSongsPlaylistID {
+			if err := SaveTracks(accessToken, changeTrackIDs(changes)); err != nil {
+				return remaining(), err
+			}
+			markUndone(undone, group)
+			continue
+		}
+
+		// Re-inserting in ascending order restores every original position
+		sort.SliceStable(group, func(i, j int) bool {
+			return action.Changes[group[i]].Position < action.Changes[group[j]].Position
+		})
+		for _, i := range group {
+			change := action.Changes[i]
+			_, err := AddTracksToPlaylist(accessToken, change.PlaylistID, []string{change.TrackURI}, change.Position)
+			if err != nil {
+				return remaining(), err
+			}
+			undone[i] = true
+		}
+	}
+
+	return nil, nil
+}
+
+func selectChanges(changes []PlaylistChange, indexes []int) []PlaylistChange {
+	var selected []PlaylistChange
+	for _, i := range indexes {
+		selected = append(selected, changes[i])
+	}
+	return selected
+}
+
+func markUndone(undone []bool, indexes []int) {
+	for _, i := range indexes {
+		undone[i] = true
+	}
+}
+
+func changeTrackIDs(changes []PlaylistChange) []string {
+	var trackIDs []string
+	for _, change := range changes {
+		trackIDs = append(trackIDs, strings.TrimPrefix(change.TrackURI, "spotify:track:"))
+	}
+	return trackIDs
+}
+
+// Groups the changes at the given indexes by playlist, keeping their original order
+func groupChangesByPlaylist(changes []PlaylistChange, indexes []int) [][]int {
+	var groups [][]int
+	indexByPlaylist := map[string]int{}
+	for _, index := range indexes {
+		playlistID := changes[index].PlaylistID
+		i, exists := indexByPlaylist[playlistID]
+		if !exists {
+			i = len(groups)
+			indexByPlaylist[playlistID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], index)
+	}
+	return groups
+}
+
+// Records an action as the most recent one for a user, keeping only the last few
+func PushAction(userID string, action PlaylistAction, conn redis.Conn) error {
+	defer conn.Close()
+
+	data, err := json.Marshal(action)
+	if err != nil {
+		return fmt.Errorf("failed to marshal action: %v", err)
+	}
+
+	key := fmt.Sprintf("actions:%s", userID)
+	_, err = conn.Do("LPUSH", key, data)
+	if err != nil {
+		return fmt.Errorf("failed to record action: %v", err)
+	}
+	_, err = conn.Do("LTRIM", key, 0, maxUndoActions-1)
+	if err != nil {
+		return fmt.Errorf("failed to trim actions: %v", err)
+	}
+
+	return nil
+}
+
+// Removes and returns the most recent action for a user, or nil if there is none
+func PopAction(userID string, conn redis.Conn) (*PlaylistAction, error) {
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("LPOP", fmt.Sprintf("actions:%s", userID)))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve last action: %v", err)
+	}
+
+	var action PlaylistAction
+	err = json.Unmarshal(data, &action)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal action: %v", err)
+	}
+
+	return &action, nil
+}
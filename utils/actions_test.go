@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushPopAction(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	first := PlaylistAction{Type: ActionAdd, CreatedAt: time.Now(), Changes: []PlaylistChange{{TrackURI: "spotify:track:a", Position: 3}}}
+	second := PlaylistAction{Type: ActionRemove, CreatedAt: time.Now(), Changes: []PlaylistChange{{TrackURI: "spotify:track:b", Position: 7}}}
+	require.NoError(t, PushAction("user-1", first, mock))
+	require.NoError(t, PushAction("user-1", second, mock))
+
+	action, err := PopAction("user-1", mock)
+	require.NoError(t, err)
+	assert.Equal(t, ActionRemove, action.Type)
+	assert.Equal(t, 7, action.Changes[0].Position)
+
+	action, err = PopAction("user-1", mock)
+	require.NoError(t, err)
+	assert.Equal(t, ActionAdd, action.Type)
+
+	action, err = PopAction("user-1", mock)
+	assert.NoError(t, err)
+	assert.Nil(t, action)
+}
+
+func TestPushAction_KeepsOnlyRecentActions(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+	for i := 0; i < maxUndoActions+5; i++ {
+		require.NoError(t, PushAction("user-1", PlaylistAction{Type: ActionAdd}, mock))
+	}
+	assert.Len(t, mock.lists["actions:user-1"], maxUndoActions)
+}
+
+func TestPopAction_RedisError(t *testing.T) {
+	errConn := &errorConn{mockConn: &mockConn{data: map[string][]byte{}}}
+	_, err := PopAction("user-1", errConn)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to retrieve last action")
+}
+
+func TestPlaylistAction_Describe(t *testing.T) {
+	action := PlaylistAction{Type: ActionAdd, Changes: []PlaylistChange{
+		{TrackURI: "spotify:track:a", TrackName: "Yellow", PlaylistID: "1", PlaylistName: "Gym"},
+		{TrackURI: "spotify:track:a", TrackName: "Yellow", PlaylistID: "2", PlaylistName: "Running"},
+	}}
+	assert.Equal(t, "adding Yellow to Gym and Running", action.Describe())
+
+	action = PlaylistAction{Type: ActionRemove, Changes: []PlaylistChange{
+		{TrackURI: "spotify:track:a", TrackName: "Yellow", PlaylistID: "1", PlaylistName: "Gym"},
+		{TrackURI: "spotify:track:b", TrackName: "Clocks", PlaylistID: "1", PlaylistName: "Gym"},
+	}}
+	assert.Equal(t, "removing 2 songs from Gym", action.Describe())
+}
+
+func TestJoinNames(t *testing.T) {
+	for expected, names := range map[string][]string{
+		"":              nil,
+		"A":             {"A"},
+		"A and B":       {"A", "B"},
+		"A, B and C":    {"A", "B", "C"},
+		"A, B, C and D": {"A", "B", "C", "D"},
+	} {
+		assert.Equal(t, expected, JoinNames(names), fmt.Sprint(names))
+	}
+}
+
+func TestPlaylistAction_DescribeMove(t *testing.T) {
+	action := PlaylistAction{Type: ActionMove, Changes: []PlaylistChange{
+		{Type: ActionAdd, TrackURI: "spotify:track:a", TrackName: "Yellow", PlaylistID: "2", PlaylistName: "Archive"},
+		{Type: ActionRemove, TrackURI: "spotify:track:a", TrackName: "Yellow", PlaylistID: "1", PlaylistName: "Gym"},
+	}}
+	assert.Equal(t, "moving Yellow from Gym to Archive", action.Describe())
+}
+
+func TestUndoAction_UnknownChangeType(t *testing.T) {
+	action := PlaylistAction{Type: "like", Changes: []PlaylistChange{{TrackURI: "spotify:track:a"}}}
+	remaining, err := UndoAction("token", action)
+	assert.Error(t, err)
+	assert.Equal(t, action.Changes, remaining)
+}
+
+func TestUndoAction_ReturnsRemainingChanges(t *testing.T) {
+	var inserted []string
+	fakeSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		// The second playlist fails, after the first one's track was put back
+		if strings.Contains(r.URL.Path, "/playlists/2/") {
+			http.Error(w, `{"error": {"status": 502, "message": "Bad gateway"}}`, http.StatusBadGateway)
+			return
+		}
+		inserted = append(inserted, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"snapshot_id": "snapshot"}`))
+	})
+
+	action := PlaylistAction{Type: ActionRemove, Changes: []PlaylistChange{
+		{TrackURI: "spotify:track:a", PlaylistID: "1", Position: 3},
+		{TrackURI: "spotify:track:a", PlaylistID: "2", Position: 5},
+	}}
+	remaining, err := UndoAction("token", action)
+
+	assert.Error(t, err)
+	assert.Equal(t, []string{"/v1/playlists/1/tracks"}, inserted)
+	assert.Equal(t, action.Changes[1:], remaining)
+}
+
+func TestUndoAction_Success(t *testing.T) {
+	fakeSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(`{"snapshot_id": "snapshot"}`))
+	})
+
+	action := PlaylistAction{Type: ActionMove, Changes: []PlaylistChange{
+		{Type: ActionAdd, TrackURI: "spotify:track:a", PlaylistID: "2", Position: 0, SnapshotID: "s1"},
+		{Type: ActionRemove, TrackURI: "spotify:track:a", PlaylistID: "1", Position: 3},
+	}}
+	remaining, err := UndoAction("token", action)
+
+	assert.NoError(t, err)
+	assert.Empty(t, remaining)
+}
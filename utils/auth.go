@@ -1,10 +1,10 @@
 package utils
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"net/http"
 	"net/url"
@@ -41,8 +41,7 @@ func ExchangeCodeForToken(code string) (*SpotifyAccessToken, error) {
 	req.SetBasicAuth(os.Getenv("SPOTIFY_CLIENT_ID"), os.Getenv("SPOTIFY_CLIENT_SECRET"))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := spotifyClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +54,7 @@ func ExchangeCodeForToken(code string) (*SpotifyAccessToken, error) {
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to get access token: %s", body)
+		return nil, fmt.Errorf("failed to get access token: %s", spotifyErrorMessage(body))
 	}
 
 	err = json.Unmarshal(body, &token)
@@ -72,8 +71,7 @@ func GetSpotifyUserID(accessToken string) (string, error) {
 	}
 	req.Header.Add("Authorization", "Bearer "+accessToken)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := spotifyClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -81,7 +79,7 @@ func GetSpotifyUserID(accessToken string) (string, error) {
 
 	if resp.StatusCode != 200 {
 		body, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to fetch user ID: %s", body)
+		return "", fmt.Errorf("failed to fetch user ID: %s", spotifyErrorMessage(body))
 	}
 
 	var data struct {
@@ -102,7 +100,18 @@ func GenerateAPIKey() string {
 	return string(apiKey)
 }
 
+// Exchanges a refresh token for a new access token, counting refreshes and failures
 func RefreshSpotifyToken(refreshToken string) (*SpotifyAccessToken, error) {
+	token, err := refreshSpotifyToken(refreshToken)
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	addMetric(1, "spotify_token_refreshes_total", "result", result)
+	return token, err
+}
+
+func refreshSpotifyToken(refreshToken string) (*SpotifyAccessToken, error) {
 	// Prepare request data
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
@@ -115,8 +124,7 @@ func RefreshSpotifyToken(refreshToken string) (*SpotifyAccessToken, error) {
 	req.SetBasicAuth(os.Getenv("SPOTIFY_CLIENT_ID"), os.Getenv("SPOTIFY_CLIENT_SECRET"))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := spotifyClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -130,7 +138,7 @@ func RefreshSpotifyToken(refreshToken string) (*SpotifyAccessToken, error) {
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to refresh token: %s", body)
+		return nil, fmt.Errorf("failed to refresh token: %s", spotifyErrorMessage(body))
 	}
 
 	err = json.Unmarshal(body, &newToken)
@@ -138,7 +146,11 @@ func RefreshSpotifyToken(refreshToken string) (*SpotifyAccessToken, error) {
 		return nil, err
 	}
 
-	log.Println("✅ Successfully refreshed Spotify token!")
-
 	return &newToken, nil
 }
+
+// HasBearerToken reports whether a request is authorized with token as a bearer token. The
+// comparison takes the same time however much of the header matches
+func HasBearerToken(r *http.Request, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"net/http/httptest"
 	"testing"
 )
 
@@ -10,3 +11,18 @@ func TestGenerateAPIKey_Length(t *testing.T) {
 		t.Errorf("expected API key length 32, got %d", len(key))
 	}
 }
+
+func TestHasBearerToken(t *testing.T) {
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	if !HasBearerToken(req, "s3cret") {
+		t.Errorf("expected the token to match")
+	}
+	if HasBearerToken(req, "s3cre") || HasBearerToken(req, "other") {
+		t.Errorf("expected other tokens not to match")
+	}
+	if HasBearerToken(httptest.NewRequest("GET", "/metrics", nil), "s3cret") {
+		t.Errorf("expected a request without a token not to match")
+	}
+}
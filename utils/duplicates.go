@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DuplicateOptions controls how loosely tracks are considered the same
+type DuplicateOptions struct {
+	// MatchTitleArtist also treats tracks with the same normalized title and first artist as
+	// duplicates, e.g. a remaster or a live version
+	MatchTitleArtist bool
+}
+
+// Parenthesized or bracketed qualifiers such as "(Remastered 2011)" or "[feat. X]", and dashed
+// suffixes such as " - Single Version"
+var titleQualifiers = regexp.MustCompile(`\s*[\(\[][^\)\]]*[\)\]]|\s+-\s+.*$`)
+
+// DuplicateKeys returns every key under which a track is considered the same as another: its ID,
+// the ID it was relinked from, its ISRC, and optionally its normalized title and artist
+func DuplicateKeys(track Track, options DuplicateOptions) []string {
+	var keys []string
+	if track.ID != "" {
+		keys = append(keys, "id:"+track.ID)
+	}
+	if track.LinkedFrom != nil && track.LinkedFrom.ID != "" {
+		keys = append(keys, "id:"+track.LinkedFrom.ID)
+	}
+	if track.ExternalIDs.ISRC != "" {
+		keys = append(keys, "isrc:"+strings.ToUpper(track.ExternalIDs.ISRC))
+	}
+	if options.MatchTitleArtist && len(track.Artists) > 0 {
+		title := NormalizeName(titleQualifiers.ReplaceAllString(track.Name, ""))
+		artist := NormalizeName(track.Artists[0].Name)
+		if title != "" && artist != "" {
+			keys = append(keys, "title:"+title+"|"+artist)
+		}
+	}
+	return keys
+}
+
+// FindDuplicatePositions returns the positions of every playlist item that duplicates track
+func FindDuplicatePositions(items []PlaylistItem, track Track, options DuplicateOptions) []int {
+	trackKeys := map[string]bool{}
+	for _, key := range DuplicateKeys(track, options) {
+		trackKeys[key] = true
+	}
+
+	var positions []int
+	for i, item := range items {
+		if item.Track == nil {
+			continue
+		}
+		for _, key := range DuplicateKeys(*item.Track, options) {
+			if trackKeys[key] {
+				positions = append(positions, i)
+				break
+			}
+		}
+	}
+	return positions
+}
+
+// GroupDuplicates groups the positions of playlist items that are the same track. Only groups
+// with more than one item are returned, each sorted by position, ordered by their first position
+func GroupDuplicates(items []PlaylistItem, options DuplicateOptions) [][]int {
+	// Union-find over positions, joining any two items that share a key
+	parent := make([]int, len(items))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	firstByKey := map[string]int{}
+	for i, item := range items {
+		if item.Track == nil {
+			continue
+		}
+		for _, key := range DuplicateKeys(*item.Track, options) {
+			if first, exists := firstByKey[key]; exists {
+				parent[find(i)] = find(first)
+			} else {
+				firstByKey[key] = i
+			}
+		}
+	}
+
+	members := map[int][]int{}
+	for i := range items {
+		if items[i].Track != nil {
+			root := find(i)
+			members[root] = append(members[root], i)
+		}
+	}
+
+	var groups [][]int
+	for _, positions := range members {
+		if len(positions) > 1 {
+			groups = append(groups, positions)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+
+	return groups
+}
+
+// DuplicateRemoval is a playlist item to remove because an earlier-added copy is kept
+type DuplicateRemoval struct {
+	Position     int
+	KeptPosition int
+	Track        Track
+}
+
+// PlanDedupe picks, for every group of duplicates, the earliest-added copy to keep and returns
+// the rest for removal, ordered by position
+func PlanDedupe(items []PlaylistItem, options DuplicateOptions) []DuplicateRemoval {
+	var removals []DuplicateRemoval
+	for _, group := range GroupDuplicates(items, options) {
+		kept := group[0]
+		for _, position := range group[1:] {
+			if items[position].AddedAt.Before(items[kept].AddedAt) {
+				kept = position
+			}
+		}
+
+		for _, position := range group {
+			if position != kept {
+				removals = append(removals, DuplicateRemoval{Position: position, KeptPosition: kept, Track: *items[position].Track})
+			}
+		}
+	}
+
+	sort.Slice(removals, func(i, j int) bool {
+		return removals[i].Position < removals[j].Position
+	})
+	return removals
+}
+
+// RemovePlaylistPositions removes the items at the given positions of the playlist version
+// snapshotID. Spotify takes at most 100 tracks per request, so removals are sent from the end
+// of the playlist backwards, which keeps the positions of earlier items valid between requests.
+// Returns the playlist's final snapshot ID
+func RemovePlaylistPositions(accessToken, playlistID, snapshotID string, items []PlaylistItem, positions []int) (string, error) {
+	sorted := append([]int(nil), positions...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+
+	for start := 0; start < len(sorted); start += maxTracksPerAdd {
+		end := min(start+maxTracksPerAdd, len(sorted))
+
+		var tracks []PlaylistTrackRef
+		indexByURI := map[string]int{}
+		for _, position := range sorted[start:end] {
+			// Tracks are referred to as they were saved, before any relinking
+			uri := items[position].Track.SavedURI()
+
+			i, exists := indexByURI[uri]
+			if !exists {
+				i = len(tracks)
+				indexByURI[uri] = i
+				tracks = append(tracks, PlaylistTrackRef{URI: uri})
+			}
+			tracks[i].Positions = append(tracks[i].Positions, position)
+		}
+
+		var err error
+		snapshotID, err = RemoveTracksFromPlaylist(accessToken, playlistID, tracks, snapshotID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return snapshotID, nil
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTrack(id, name, artist, isrc string) *Track {
+	track := &Track{ID: id, Name: name}
+	track.Artists = append(track.Artists, struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}{Name: artist})
+	track.ExternalIDs.ISRC = isrc
+	return track
+}
+
+func TestFindDuplicatePositions_IDsAndISRC(t *testing.T) {
+	relinked := testTrack("relinked", "Yellow", "Coldplay", "")
+	relinked.LinkedFrom = &struct {
+		ID string `json:"id"`
+	}{ID: "original"}
+
+	items := []PlaylistItem{
+		{Track: testTrack("a", "Clocks", "Coldplay", "GBAYE0200771")},
+		{Track: relinked},
+		{Track: nil},
+		{Track: testTrack("single", "Viva La Vida", "Coldplay", "GBAYE0800265")},
+	}
+
+	assert.Equal(t, []int{1}, FindDuplicatePositions(items, *testTrack("original", "Yellow", "Coldplay", ""), DuplicateOptions{}))
+	assert.Equal(t, []int{3}, FindDuplicatePositions(items, *testTrack("album", "Viva La Vida", "Coldplay", "gbaye0800265"), DuplicateOptions{}))
+	assert.Empty(t, FindDuplicatePositions(items, *testTrack("b", "Fix You", "Coldplay", "GBAYE0500599"), DuplicateOptions{}))
+}
+
+func TestFindDuplicatePositions_TitleArtist(t *testing.T) {
+	items := []PlaylistItem{
+		{Track: testTrack("a", "Yellow - Remastered 2011", "Coldplay", "ISRC1")},
+	}
+	live := *testTrack("b", "Yellow (Live)", "Coldplay", "ISRC2")
+
+	assert.Empty(t, FindDuplicatePositions(items, live, DuplicateOptions{}))
+	assert.Equal(t, []int{0}, FindDuplicatePositions(items, live, DuplicateOptions{MatchTitleArtist: true}))
+	assert.Empty(t, FindDuplicatePositions(items, *testTrack("c", "Yellow", "Someone Else", "ISRC3"), DuplicateOptions{MatchTitleArtist: true}))
+}
+
+func TestGroupDuplicates(t *testing.T) {
+	items := []PlaylistItem{
+		{Track: testTrack("a", "Clocks", "Coldplay", "ISRC1")},
+		{Track: testTrack("b", "Yellow", "Coldplay", "ISRC2")},
+		{Track: testTrack("c", "Clocks", "Coldplay", "ISRC1")},
+		{Track: testTrack("b", "Yellow", "Coldplay", "ISRC2")},
+		{Track: testTrack("d", "Fix You", "Coldplay", "ISRC3")},
+		{Track: testTrack("a", "Clocks", "Coldplay", "")},
+	}
+
+	assert.Equal(t, [][]int{{0, 2, 5}, {1, 3}}, GroupDuplicates(items, DuplicateOptions{}))
+	assert.Empty(t, GroupDuplicates(items[:2], DuplicateOptions{}))
+}
+
+func TestPlanDedupe_KeepsEarliestAdded(t *testing.T) {
+	now := time.Now()
+	items := []PlaylistItem{
+		{Track: testTrack("a", "Clocks", "Coldplay", "ISRC1"), AddedAt: now},
+		{Track: testTrack("b", "Yellow", "Coldplay", "ISRC2"), AddedAt: now},
+		{Track: testTrack("c", "Clocks", "Coldplay", "ISRC1"), AddedAt: now.Add(-time.Hour)},
+		{Track: testTrack("b", "Yellow", "Coldplay", "ISRC2"), AddedAt: now.Add(time.Hour)},
+		{Track: testTrack("a", "Clocks", "Coldplay", ""), AddedAt: now},
+	}
+
+	removals := PlanDedupe(items, DuplicateOptions{})
+
+	var positions, kept []int
+	for _, removal := range removals {
+		positions = append(positions, removal.Position)
+		kept = append(kept, removal.KeptPosition)
+	}
+	assert.Equal(t, []int{0, 3, 4}, positions)
+	assert.Equal(t, []int{2, 1, 2}, kept)
+	assert.Equal(t, "b", removals[1].Track.ID)
+	assert.Empty(t, PlanDedupe(items[:2], DuplicateOptions{}))
+}
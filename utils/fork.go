@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Spotify accepts at most 100 tracks per add request
+const maxTracksPerAdd = 100
+
+// ForkPlaylist copies a playlist the user can't edit into their own account, leaving out the
+// item at skipPosition. Local files can't be added through the API, so they are left out too.
+// Returns the fork and the position in the fork of the item that followed the skipped one
+func ForkPlaylist(accessToken, userID string, source *Playlist, items []PlaylistItem, skipPosition int) (*Playlist, int, error) {
+	uris, nextPosition := ForkTrackURIs(items, skipPosition)
+
+	description := fmt.Sprintf("Your copy of %s", source.Name)
+	fork, err := CreatePlaylist(accessToken, userID, source.Name, description, false)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for start := 0; start < len(uris); start += maxTracksPerAdd {
+		end := min(start+maxTracksPerAdd, len(uris))
+		fork.SnapshotID, err = AddTracksToPlaylist(accessToken, fork.ID, uris[start:end], -1)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	fork.Tracks.Total = len(uris)
+
+	return fork, nextPosition, nil
+}
+
+// Lists the URIs to copy into a fork, and where the item after skipPosition ends up
+func ForkTrackURIs(items []PlaylistItem, skipPosition int) ([]string, int) {
+	var uris []string
+	nextPosition := 0
+	for i, item := range items {
+		if i == skipPosition {
+			nextPosition = len(uris)
+			continue
+		}
+		if item.Track == nil || item.Track.URI == "" || strings.HasPrefix(item.Track.URI, "spotify:local:") {
+			continue
+		}
+		uris = append(uris, item.Track.URI)
+	}
+	return uris, nextPosition
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForkTrackURIs(t *testing.T) {
+	items := []PlaylistItem{
+		{Track: &Track{URI: "spotify:track:a"}},
+		{Track: &Track{URI: "spotify:local:artist:album:song:180"}},
+		{Track: &Track{URI: "spotify:track:b"}},
+		{Track: nil},
+		{Track: &Track{URI: "spotify:track:c"}},
+		{Track: &Track{URI: "spotify:episode:d"}},
+	}
+
+	uris, nextPosition := ForkTrackURIs(items, 2)
+	assert.Equal(t, []string{"spotify:track:a", "spotify:track:c", "spotify:episode:d"}, uris)
+	assert.Equal(t, 1, nextPosition)
+
+	uris, nextPosition = ForkTrackURIs(items, 5)
+	assert.Equal(t, []string{"spotify:track:a", "spotify:track:b", "spotify:track:c"}, uris)
+	assert.Equal(t, 3, nextPosition)
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Minimum score for a playlist to be considered a match at all
+const FuzzyMatchThreshold = 0.5
+
+// Playlists scoring within this margin of the best match are considered equally good
+const FuzzyMatchAmbiguityMargin = 0.1
+
+// PlaylistMatch is a playlist paired with how closely it matches a spoken name
+type PlaylistMatch struct {
+	Playlist Playlist
+	Score    float64
+}
+
+var diacriticFolds = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ß': "ss", 'ť': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// NormalizeName lowercases a name, folds diacritics and drops emoji and punctuation,
+// so "Chill Vibes ✨" and "chill vibes" normalize to the same string
+func NormalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if folded, ok := diacriticFolds[r]; ok {
+			b.WriteString(folded)
+		} else if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// FuzzyScore returns how closely two names match, from 0 (nothing in common) to 1 (identical
+// once normalized)
+func FuzzyScore(query, name string) float64 {
+	q, n := NormalizeName(query), NormalizeName(name)
+	if q == "" || n == "" {
+		return 0
+	}
+	if q == n {
+		return 1
+	}
+
+	// Character-level similarity catches mishearings like "chil vibes"
+	score := 1 - float64(levenshtein(q, n))/float64(max(len([]rune(q)), len([]rune(n))))
+
+	// Word-level overlap catches extra or reordered words like "vibes chill" or "chill vibes 2026"
+	queryWords, nameWords := strings.Fields(q), strings.Fields(n)
+	matched := 0
+	for _, qw := range queryWords {
+		for _, nw := range nameWords {
+			if qw == nw {
+				matched++
+				break
+			}
+		}
+	}
+	overlap := float64(matched) / float64(max(len(queryWords), len(nameWords)))
+	if overlap > score {
+		score = overlap
+	}
+
+	// A name starting with the whole query is a strong signal, e.g. "gym" vs "gym bangers"
+	if strings.HasPrefix(n, q+" ") && score < 0.9 {
+		score = 0.9
+	}
+
+	return score
+}
+
+// MatchPlaylists scores playlists against a spoken name and returns those above
+// FuzzyMatchThreshold, best match first
+func MatchPlaylists(query string, playlists []Playlist) []PlaylistMatch {
+	var matches []PlaylistMatch
+	for _, playlist := range playlists {
+		score := FuzzyScore(query, playlist.Name)
+		if score >= FuzzyMatchThreshold {
+			matches = append(matches, PlaylistMatch{Playlist: playlist, Score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	return matches
+}
+
+// BestPlaylistMatches returns the single best match, or every match scoring about as well as
+// the best one when the choice is ambiguous
+func BestPlaylistMatches(query string, playlists []Playlist) []PlaylistMatch {
+	matches := MatchPlaylists(query, playlists)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	// An exact match always wins
+	if matches[0].Score == 1 && (len(matches) == 1 || matches[1].Score < 1) {
+		return matches[:1]
+	}
+
+	best := matches[:1]
+	for _, match := range matches[1:] {
+		if matches[0].Score-match.Score > FuzzyMatchAmbiguityMargin {
+			break
+		}
+		best = append(best, match)
+	}
+
+	return best
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeName(t *testing.T) {
+	assert.Equal(t, "chill vibes", NormalizeName("Chill Vibes ✨"))
+	assert.Equal(t, "cafe del mar", NormalizeName("Café  del Mar!"))
+	assert.Equal(t, "2026 favorites", NormalizeName("🔥 2026 Favorites 🔥"))
+}
+
+func TestFuzzyScore(t *testing.T) {
+	assert.Equal(t, 1.0, FuzzyScore("chill vibes", "Chill Vibes ✨"))
+	assert.Greater(t, FuzzyScore("chil vibes", "Chill Vibes"), FuzzyMatchThreshold)
+	assert.Greater(t, FuzzyScore("gym", "Gym Bangers"), FuzzyMatchThreshold)
+	assert.Less(t, FuzzyScore("gym", "Road Trip"), FuzzyMatchThreshold)
+}
+
+func TestBestPlaylistMatches_ExactWins(t *testing.T) {
+	playlists := []Playlist{{ID: "1", Name: "Chill Vibes ✨"}, {ID: "2", Name: "Chill Vibes 2"}, {ID: "3", Name: "Road Trip"}}
+	matches := BestPlaylistMatches("chill vibes", playlists)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, "1", matches[0].Playlist.ID)
+}
+
+func TestBestPlaylistMatches_Ambiguous(t *testing.T) {
+	playlists := []Playlist{{ID: "1", Name: "Gym Bangers"}, {ID: "2", Name: "Gym Chill"}, {ID: "3", Name: "Road Trip"}}
+	matches := BestPlaylistMatches("gym", playlists)
+	assert.Len(t, matches, 2)
+}
+
+func TestBestPlaylistMatches_NoMatch(t *testing.T) {
+	playlists := []Playlist{{ID: "1", Name: "Road Trip"}}
+	assert.Empty(t, BestPlaylistMatches("jazz", playlists))
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Longest a readiness check waits for a dependency
+const healthCheckTimeout = 3 * time.Second
+
+// Hosts whose reachability is checked, by dependency name
+var spotifyHealthURLs = map[string]string{
+	"spotify_accounts": "https://accounts.spotify.com/",
+	"spotify_api":      SpotifyAPIBaseURL,
+}
+
+// Checks don't go through spotifyClient, so uptime monitors don't skew the Spotify metrics
+var healthClient = &http.Client{Timeout: healthCheckTimeout}
+
+// Error reported for a failed dependency. Readiness is public, so the cause, which can name
+// hosts, is only logged
+const dependencyCheckFailed = "check failed"
+
+// DependencyStatus reports whether a dependency is usable and how long checking it took
+type DependencyStatus struct {
+	// Status is "ok" or "error"
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	// err is why the check failed, for the logs
+	err error
+}
+
+// Times a check and reports its result
+func timeCheck(check func() error) DependencyStatus {
+	start := time.Now()
+	err := check()
+	status := DependencyStatus{
+		Status:    "ok",
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = "error"
+		status.Error = dependencyCheckFailed
+		status.err = err
+	}
+	return status
+}
+
+// CheckReadiness checks every dependency concurrently, logging why any of them failed with
+// logger. ready is true if they are all ok. Only a deep check writes to Redis and reaches
+// Spotify; otherwise Redis just has to answer PING
+func CheckReadiness(logger *slog.Logger, deep bool) (checks map[string]DependencyStatus, ready bool) {
+	checks = map[string]DependencyStatus{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	run := func(name string, check func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status := timeCheck(check)
+			mu.Lock()
+			checks[name] = status
+			mu.Unlock()
+		}()
+	}
+
+	run("redis", func() error {
+		return checkRedisURL(os.Getenv("KV_URL"), deep)
+	})
+	if deep {
+		for name, url := range spotifyHealthURLs {
+			url := url
+			run(name, func() error {
+				return checkHost(url)
+			})
+		}
+	}
+	wg.Wait()
+
+	ready = true
+	for name, status := range checks {
+		if status.Status != "ok" {
+			logger.Warn("Dependency check failed", "dependency", name, "error", status.err)
+			ready = false
+		}
+	}
+	return checks, ready
+}
+
+// Connects to Redis with a timeout, unlike InitRedis, which exits when it can't connect
+func checkRedisURL(redisURL string, write bool) error {
+	if redisURL == "" {
+		return fmt.Errorf("KV_URL environment variable is not set")
+	}
+	conn, err := redis.DialURL(redisURL,
+		redis.DialConnectTimeout(healthCheckTimeout),
+		redis.DialReadTimeout(healthCheckTimeout),
+		redis.DialWriteTimeout(healthCheckTimeout),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+	return checkRedis(conn, write)
+}
+
+// Checks that Redis answers PING and, if write is true, that a value written to it can be read
+// back
+func checkRedis(conn redis.Conn, write bool) error {
+	defer conn.Close()
+
+	pong, err := redis.String(conn.Do("PING"))
+	if err != nil {
+		return fmt.Errorf("failed to ping Redis: %v", err)
+	}
+	if pong != "PONG" {
+		return fmt.Errorf("unexpected reply to PING: %q", pong)
+	}
+	if !write {
+		return nil
+	}
+
+	value, err := randomHex(8)
+	if err != nil {
+		return fmt.Errorf("failed to generate health check value: %v", err)
+	}
+	// A key per check, so concurrent checks don't read each other's values
+	key := "healthcheck:" + value
+	_, err = conn.Do("SET", key, value, "EX", 60)
+	if err != nil {
+		return fmt.Errorf("failed to write to Redis: %v", err)
+	}
+	read, err := redis.String(conn.Do("GET", key))
+	if err != nil {
+		return fmt.Errorf("failed to read from Redis: %v", err)
+	}
+	if read != value {
+		return fmt.Errorf("read %q from Redis after writing %q", read, value)
+	}
+	_, err = conn.Do("DEL", key)
+	if err != nil {
+		return fmt.Errorf("failed to delete from Redis: %v", err)
+	}
+
+	return nil
+}
+
+// Checks that a host answers HTTP requests. Any response short of a server error will do,
+// since the checks aren't authorized
+func checkHost(url string) error {
+	resp, err := healthClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return nil
+}
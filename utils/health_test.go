@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRedis_Success(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	err := checkRedis(mock, true)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PING", "SET", "GET", "DEL"}, mock.calls)
+	assert.Empty(t, mock.data)
+}
+
+func TestCheckRedis_PingOnly(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	require.NoError(t, checkRedis(mock, false))
+	assert.Equal(t, []string{"PING"}, mock.calls)
+}
+
+func TestCheckRedis_Error(t *testing.T) {
+	errConn := &errorConn{mockConn: &mockConn{data: map[string][]byte{}}}
+
+	err := checkRedis(errConn, true)
+
+	assert.ErrorContains(t, err, "failed to ping Redis")
+}
+
+func TestCheckRedisURL_MissingURL(t *testing.T) {
+	err := checkRedisURL("", false)
+
+	assert.ErrorContains(t, err, "KV_URL")
+}
+
+func TestCheckHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		wantErr    bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"unauthorized", http.StatusUnauthorized, false},
+		{"not found", http.StatusNotFound, false},
+		{"server error", http.StatusBadGateway, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer server.Close()
+
+			err := checkHost(server.URL)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckHost_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	err := checkHost(server.URL)
+
+	assert.ErrorContains(t, err, "failed to reach")
+}
+
+func TestTimeCheck(t *testing.T) {
+	status := timeCheck(func() error { return nil })
+	assert.Equal(t, "ok", status.Status)
+	assert.Empty(t, status.Error)
+	assert.GreaterOrEqual(t, status.LatencyMs, 0.0)
+
+	status = timeCheck(func() error { return errors.New("failed to connect to Redis: dial tcp redis.internal:6379") })
+	assert.Equal(t, "error", status.Status)
+	assert.Equal(t, "check failed", status.Error)
+	assert.Error(t, status.err)
+}
+
+func TestCheckReadiness(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	originalURLs := spotifyHealthURLs
+	spotifyHealthURLs = map[string]string{"spotify_accounts": server.URL, "spotify_api": server.URL + "/v1"}
+	defer func() { spotifyHealthURLs = originalURLs }()
+	t.Setenv("KV_URL", "")
+
+	var logs bytes.Buffer
+	checks, ready := CheckReadiness(NewLogger(&logs, "text", ""), true)
+
+	assert.False(t, ready)
+	assert.Contains(t, logs.String(), "KV_URL environment variable is not set")
+	require.Len(t, checks, 3)
+	assert.Equal(t, "error", checks["redis"].Status)
+	assert.Equal(t, "ok", checks["spotify_accounts"].Status)
+	assert.Equal(t, "ok", checks["spotify_api"].Status)
+
+	// Without a deep check, Spotify isn't contacted
+	checks, _ = CheckReadiness(NewLogger(&logs, "text", ""), false)
+	assert.Len(t, checks, 1)
+	assert.Contains(t, checks, "redis")
+}
@@ -0,0 +1,252 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Types of history events
+const (
+	HistoryAdd    = "add"
+	HistoryRemove = "remove"
+	HistorySkip   = "skip"
+	HistoryUndo   = "undo"
+	// HistoryLike is a change to the user's Liked Songs, with the result "liked" or "unliked"
+	HistoryLike = "like"
+)
+
+// Number of history events kept per user. Redis trims the stream approximately, so a few more
+// may be kept
+const maxHistoryEvents = 10000
+
+// HistoryEvent is something done on the user's behalf, stored in the history:<userID> stream
+type HistoryEvent struct {
+	// ID is the stream entry ID, which also orders events and serves as a pagination cursor
+	ID           string    `json:"id"`
+	Time         time.Time `json:"time"`
+	Type         string    `json:"type"`
+	Track        string    `json:"track,omitempty"`
+	TrackName    string    `json:"track_name,omitempty"`
+	PlaylistID   string    `json:"playlist_id,omitempty"`
+	PlaylistName string    `json:"playlist_name,omitempty"`
+	// Result is what happened, e.g. "added", "duplicate" or "error"
+	Result string `json:"result"`
+	// Source labels the API key that made the request
+	Source string `json:"source"`
+}
+
+// ErrInvalidCursor is returned for a history cursor that is not a stream ID
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// HistoryFields are the stream fields of an event, in export order
+var HistoryFields = []string{"type", "track", "track_name", "playlist_id", "playlist_name", "result", "source"}
+
+func (e HistoryEvent) fieldValue(field string) string {
+	switch field {
+	case "type":
+		return e.Type
+	case "track":
+		return e.Track
+	case "track_name":
+		return e.TrackName
+	case "playlist_id":
+		return e.PlaylistID
+	case "playlist_name":
+		return e.PlaylistName
+	case "result":
+		return e.Result
+	case "source":
+		return e.Source
+	}
+	return ""
+}
+
+func (e *HistoryEvent) setFieldValue(field, value string) {
+	switch field {
+	case "type":
+		e.Type = value
+	case "track":
+		e.Track = value
+	case "track_name":
+		e.TrackName = value
+	case "playlist_id":
+		e.PlaylistID = value
+	case "playlist_name":
+		e.PlaylistName = value
+	case "result":
+		e.Result = value
+	case "source":
+		e.Source = value
+	}
+}
+
+// KeyLabel identifies an API key in the history by the start of its hash, which logs record as
+// api_key_hash, so no part of the key ends up in exported history
+func KeyLabel(apiKey string) string {
+	return "key " + HashAPIKey(apiKey)[:8]
+}
+
+// Appends events to a user's history in a single round trip. Redis assigns each event its ID
+// and time
+func RecordHistory(userID string, events []HistoryEvent, conn redis.Conn) error {
+	defer conn.Close()
+
+	if len(events) == 0 {
+		return nil
+	}
+	key := fmt.Sprintf("history:%s", userID)
+	for _, event := range events {
+		args := redis.Args{key, "MAXLEN", "~", maxHistoryEvents, "*"}
+		for _, field := range HistoryFields {
+			if value := event.fieldValue(field); value != "" {
+				args = append(args, field, value)
+			}
+		}
+		conn.Send("XADD", args...)
+	}
+
+	err := conn.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to record history: %v", err)
+	}
+	for range events {
+		_, err = conn.Receive()
+		if err != nil {
+			return fmt.Errorf("failed to record history: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// HistoryQuery selects a page of history, newest first
+type HistoryQuery struct {
+	// From and To bound the event times, inclusive. Zero values leave the range open
+	From time.Time
+	To   time.Time
+	// Cursor continues from the last event of the previous page
+	Cursor string
+	Limit  int
+}
+
+// Retrieves a page of a user's history, newest first, with the cursor for the next page, or ""
+// if this is the last one
+func GetHistory(userID string, query HistoryQuery, conn redis.Conn) ([]HistoryEvent, string, error) {
+	defer conn.Close()
+
+	end, start := "+", "-"
+	if !query.To.IsZero() {
+		end = strconv.FormatInt(query.To.UnixMilli(), 10)
+	}
+	if !query.From.IsZero() {
+		start = strconv.FormatInt(query.From.UnixMilli(), 10)
+	}
+	if query.Cursor != "" {
+		previous, err := previousStreamID(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		if previous == "" {
+			return []HistoryEvent{}, "", nil
+		}
+		if end == "+" || compareStreamIDs(previous, end) < 0 {
+			end = previous
+		}
+	}
+
+	// One extra event tells us whether there is another page
+	entries, err := redis.Values(conn.Do("XREVRANGE", fmt.Sprintf("history:%s", userID), end, start, "COUNT", query.Limit+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to retrieve history: %v", err)
+	}
+
+	events := []HistoryEvent{}
+	for _, entry := range entries {
+		parts, err := redis.Values(entry, nil)
+		if err != nil || len(parts) != 2 {
+			return nil, "", fmt.Errorf("failed to parse history entry: %v", err)
+		}
+		id, err := redis.String(parts[0], nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse history entry ID: %v", err)
+		}
+		fields, err := redis.Strings(parts[1], nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse history entry fields: %v", err)
+		}
+
+		event := HistoryEvent{ID: id}
+		if ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64); err == nil {
+			event.Time = time.UnixMilli(ms).UTC()
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			event.setFieldValue(fields[i], fields[i+1])
+		}
+		events = append(events, event)
+	}
+
+	nextCursor := ""
+	if len(events) > query.Limit {
+		events = events[:query.Limit]
+		nextCursor = events[len(events)-1].ID
+	}
+
+	return events, nextCursor, nil
+}
+
+// Parses a stream ID of the form <milliseconds>-<sequence>
+func parseStreamID(id string) (uint64, uint64, error) {
+	ms, seq, found := strings.Cut(id, "-")
+	msValue, err := strconv.ParseUint(ms, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w %q", ErrInvalidCursor, id)
+	}
+	if !found {
+		return msValue, 0, nil
+	}
+	seqValue, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w %q", ErrInvalidCursor, id)
+	}
+	return msValue, seqValue, nil
+}
+
+// Returns the stream ID right before id, so ranges can exclude it, or "" if there is none
+func previousStreamID(id string) (string, error) {
+	ms, seq, err := parseStreamID(id)
+	if err != nil {
+		return "", err
+	}
+	if seq > 0 {
+		return fmt.Sprintf("%d-%d", ms, seq-1), nil
+	}
+	if ms == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("%d-%d", ms-1, uint64(1<<64-1)), nil
+}
+
+// Compares two stream IDs. An ID without a sequence compares as its highest sequence, as it
+// would at the end of a range
+func compareStreamIDs(a, b string) int {
+	aMs, aSeq, _ := parseStreamID(a)
+	bMs, bSeq, _ := parseStreamID(b)
+	if !strings.Contains(a, "-") {
+		aSeq = 1<<64 - 1
+	}
+	if !strings.Contains(b, "-") {
+		bSeq = 1<<64 - 1
+	}
+	switch {
+	case aMs < bMs, aMs == bMs && aSeq < bSeq:
+		return -1
+	case aMs == bMs && aSeq == bSeq:
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyLabel(t *testing.T) {
+	label := KeyLabel("4f9a1b2c3d4e5f60c0de")
+	assert.Equal(t, "key "+HashAPIKey("4f9a1b2c3d4e5f60c0de")[:8], label)
+	assert.NotContains(t, label, "c0de")
+}
+
+func TestHistory_RecordAndPage(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+	var events []HistoryEvent
+	for _, name := range []string{"Yellow", "Clocks", "Fix You"} {
+		events = append(events, HistoryEvent{Type: HistoryAdd, TrackName: name, PlaylistName: "Gym", Result: "added", Source: "key 3f2a9c1d"})
+	}
+	require.NoError(t, RecordHistory("user-1", events, mock))
+
+	page, cursor, err := GetHistory("user-1", HistoryQuery{Limit: 2}, mock)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "Fix You", page[0].TrackName)
+	assert.Equal(t, "Clocks", page[1].TrackName)
+	assert.Equal(t, HistoryAdd, page[0].Type)
+	assert.Equal(t, "key 3f2a9c1d", page[0].Source)
+	assert.Equal(t, time.UnixMilli(3000).UTC(), page[0].Time)
+	assert.Equal(t, "2000-0", cursor)
+
+	page, cursor, err = GetHistory("user-1", HistoryQuery{Limit: 2, Cursor: cursor}, mock)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "Yellow", page[0].TrackName)
+	assert.Equal(t, "", cursor)
+}
+
+func TestHistory_TimeRange(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+	events := []HistoryEvent{{Type: HistoryAdd}, {Type: HistoryRemove}, {Type: HistorySkip}, {Type: HistoryUndo}}
+	require.NoError(t, RecordHistory("user-1", events, mock))
+
+	page, _, err := GetHistory("user-1", HistoryQuery{From: time.UnixMilli(1500), To: time.UnixMilli(3000), Limit: 10}, mock)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, HistorySkip, page[0].Type)
+	assert.Equal(t, HistoryRemove, page[1].Type)
+}
+
+func TestRecordHistory_Like(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+	events := []HistoryEvent{{Type: HistoryLike, Track: "spotify:track:a", TrackName: "Yellow", Result: "unliked"}}
+	require.NoError(t, RecordHistory("user-1", events, mock))
+
+	page, _, err := GetHistory("user-1", HistoryQuery{Limit: 10}, mock)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, HistoryLike, page[0].Type)
+	assert.Equal(t, "unliked", page[0].Result)
+	assert.Empty(t, page[0].PlaylistID)
+}
+
+func TestRecordHistory_NoEvents(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+	require.NoError(t, RecordHistory("user-1", nil, mock))
+	assert.Empty(t, mock.calls)
+}
+
+func TestHistory_InvalidCursor(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	_, _, err := GetHistory("user-1", HistoryQuery{Limit: 10, Cursor: "yesterday"}, mock)
+	assert.True(t, errors.Is(err, ErrInvalidCursor))
+}
+
+func TestPreviousStreamID(t *testing.T) {
+	previous, err := previousStreamID("2000-3")
+	require.NoError(t, err)
+	assert.Equal(t, "2000-2", previous)
+
+	previous, err = previousStreamID("2000-0")
+	require.NoError(t, err)
+	assert.Equal(t, "1999-18446744073709551615", previous)
+
+	previous, err = previousStreamID("0-0")
+	require.NoError(t, err)
+	assert.Equal(t, "", previous)
+}
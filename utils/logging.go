@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Attribute keys whose values are never logged
+var secretKeys = map[string]bool{
+	"access_token":  true,
+	"api_key":       true,
+	"authorization": true,
+	"client_secret": true,
+	"code":          true,
+	"refresh_token": true,
+	"secret":        true,
+	"token":         true,
+	"x-api-key":     true,
+}
+
+// Patterns of secrets inside logged text, each with what replaces it
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`), "${1}[REDACTED]"},
+	{regexp.MustCompile(`(?i)("(?:access_token|refresh_token|client_secret|secret|api_key)"\s*:\s*")[^"]*"`), `${1}[REDACTED]"`},
+	{regexp.MustCompile(`(?i)\b((?:access_token|refresh_token|client_secret|code|api_key)=)[^&\s"]+`), "${1}[REDACTED]"},
+	{regexp.MustCompile(`whsec_[0-9a-f]+`), "whsec_[REDACTED]"},
+}
+
+// Logged text longer than this is cut short
+const maxLoggedLength = 1000
+
+func init() {
+	slog.SetDefault(NewLogger(os.Stderr, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL")))
+}
+
+// NewLogger creates a logger that redacts secrets. format is "text" (the default) or "json",
+// and level is "debug", "info" (the default), "warn" or "error"
+func NewLogger(w io.Writer, format, level string) *slog.Logger {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		logLevel = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: redactAttr}
+
+	if strings.EqualFold(format, "json") {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+	return slog.New(slog.NewTextHandler(w, options))
+}
+
+// Redacts secrets from an attribute before it is logged
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, "[REDACTED]")
+	}
+
+	switch value := attr.Value.Any().(type) {
+	case string:
+		return slog.String(attr.Key, RedactSecrets(value))
+	case error:
+		return slog.String(attr.Key, RedactSecrets(value.Error()))
+	}
+	return attr
+}
+
+// RedactSecrets replaces tokens, keys and secrets in text, and cuts it short if it is long
+func RedactSecrets(text string) string {
+	for _, secret := range secretPatterns {
+		text = secret.pattern.ReplaceAllString(text, secret.replacement)
+	}
+	if len(text) > maxLoggedLength {
+		text = text[:maxLoggedLength] + "..."
+	}
+	return text
+}
+
+// HashAPIKey identifies an API key in logs without revealing it
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:6])
+}
+
+// RequestLogger returns a logger for a request, whose lines all carry the request ID, the
+// endpoint and the hashed API key, if there is one. Handlers add the user ID once they know it.
+// The request ID is Vercel's, when there is one, and is returned in the X-Request-Id header
+func RequestLogger(w http.ResponseWriter, r *http.Request, endpoint string) *slog.Logger {
+	requestID := r.Header.Get("X-Vercel-Id")
+	if requestID == "" {
+		requestID, _ = randomHex(8)
+	}
+	w.Header().Set("X-Request-Id", requestID)
+
+	logger := slog.Default().With("request_id", requestID, "endpoint", endpoint)
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		logger = logger.With("api_key_hash", HashAPIKey(apiKey))
+	}
+	// TrackRequest logs with it too, when w is the writer it returned
+	if recorder, ok := w.(*statusRecorder); ok {
+		recorder.logger = logger
+	}
+	return logger
+}
+
+// Summarizes a Spotify error response body for error messages. Spotify's JSON errors are
+// reduced to their message rather than logged whole
+func spotifyErrorMessage(body []byte) string {
+	var apiError struct {
+		Error struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &apiError) == nil && apiError.Error.Message != "" {
+		return apiError.Error.Message
+	}
+
+	// The accounts service reports errors as {"error": "...", "error_description": "..."}
+	var authError struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &authError) == nil && authError.Error != "" {
+		return strings.TrimSpace(authError.Error + " " + authError.Description)
+	}
+
+	text := RedactSecrets(strings.TrimSpace(string(body)))
+	if len(text) > 200 {
+		text = text[:200] + "..."
+	}
+	return text
+}
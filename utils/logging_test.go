@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogger_RedactsSecretKeys(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, "text", "")
+
+	logger.Info("Refreshed token", "access_token", "abc123", "refresh_token", "def456", "user_id", "user1")
+
+	assert.NotContains(t, buf.String(), "abc123")
+	assert.NotContains(t, buf.String(), "def456")
+	assert.Contains(t, buf.String(), "access_token=[REDACTED]")
+	assert.Contains(t, buf.String(), "user_id=user1")
+}
+
+func TestNewLogger_RedactsSecretsInErrors(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, "text", "")
+
+	logger.Error("Request failed", "error", errors.New(`failed to refresh: {"access_token": "abc123"} Authorization: Bearer xyz789`))
+
+	assert.NotContains(t, buf.String(), "abc123")
+	assert.NotContains(t, buf.String(), "xyz789")
+}
+
+func TestNewLogger_JSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, "json", "")
+
+	logger.Warn("Failed to load settings", "user_id", "user1", "error", errors.New("failed to retrieve settings"))
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "WARN", line["level"])
+	assert.Equal(t, "Failed to load settings", line["msg"])
+	assert.Equal(t, "user1", line["user_id"])
+	assert.Equal(t, "failed to retrieve settings", line["error"])
+}
+
+func TestNewLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, "text", "warn")
+
+	logger.Info("Access token expired, refreshing")
+	assert.Empty(t, buf.String())
+
+	logger.Warn("Failed to record history")
+	assert.Contains(t, buf.String(), "Failed to record history")
+}
+
+func TestNewLogger_InvalidLevelDefaultsToInfo(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, "text", "verbose")
+
+	logger.Debug("Not logged")
+	logger.Info("Logged")
+
+	assert.NotContains(t, buf.String(), "Not logged")
+	assert.Contains(t, buf.String(), "Logged")
+}
+
+func TestRedactSecrets(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"bearer token", "Authorization: Bearer abc.def", "Authorization: Bearer [REDACTED]"},
+		{"JSON field", `{"refresh_token":"abc","scope":"x"}`, `{"refresh_token":"[REDACTED]","scope":"x"}`},
+		{"query parameter", "grant_type=authorization_code&code=abc&redirect_uri=x", "grant_type=authorization_code&code=[REDACTED]&redirect_uri=x"},
+		{"webhook secret", "secret whsec_0123abcd", "secret whsec_[REDACTED]"},
+		{"nothing secret", "failed to add tracks", "failed to add tracks"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, RedactSecrets(tt.text))
+		})
+	}
+}
+
+func TestRedactSecrets_Truncates(t *testing.T) {
+	text := RedactSecrets(strings.Repeat("a", 2000))
+
+	assert.Len(t, text, maxLoggedLength+len("..."))
+}
+
+func TestHashAPIKey(t *testing.T) {
+	hash := HashAPIKey("my-api-key")
+
+	assert.Len(t, hash, 12)
+	assert.NotContains(t, hash, "my-api-key")
+	assert.Equal(t, hash, HashAPIKey("my-api-key"))
+	assert.NotEqual(t, hash, HashAPIKey("other-api-key"))
+}
+
+func TestRequestLogger_UsesVercelID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/undo", nil)
+	req.Header.Set("X-Vercel-Id", "cdg1::abc-123")
+	recorder := httptest.NewRecorder()
+
+	RequestLogger(recorder, req, "undo")
+
+	assert.Equal(t, "cdg1::abc-123", recorder.Header().Get("X-Request-Id"))
+}
+
+func TestRequestLogger_GeneratesID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/undo", nil)
+	recorder := httptest.NewRecorder()
+
+	RequestLogger(recorder, req, "undo")
+
+	assert.Len(t, recorder.Header().Get("X-Request-Id"), 16)
+}
+
+func TestSpotifyErrorMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"API error", `{"error": {"status": 403, "message": "Insufficient client scope"}}`, "Insufficient client scope"},
+		{"accounts error", `{"error": "invalid_grant", "error_description": "Refresh token revoked"}`, "invalid_grant Refresh token revoked"},
+		{"plain text", "Bad gateway", "Bad gateway"},
+		{"long text", strings.Repeat("a", 300), strings.Repeat("a", 200) + "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, spotifyErrorMessage([]byte(tt.body)))
+		})
+	}
+}
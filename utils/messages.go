@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"sort"
+)
+
+// Keys of the short confirmations used for brief responses
+const (
+	MessageAdded     = "added"
+	MessageDuplicate = "duplicate"
+	MessageRemoved   = "removed"
+	MessageMoved     = "moved"
+	MessageUndone    = "undone"
+)
+
+var briefMessages = map[string]map[string]string{
+	"en": {
+		MessageAdded:     "Added",
+		MessageDuplicate: "Already there",
+		MessageRemoved:   "Removed",
+		MessageMoved:     "Moved",
+		MessageUndone:    "Undone",
+	},
+	"es": {
+		MessageAdded:     "Añadida",
+		MessageDuplicate: "Ya está",
+		MessageRemoved:   "Quitada",
+		MessageMoved:     "Movida",
+		MessageUndone:    "Deshecho",
+	},
+	"fr": {
+		MessageAdded:     "Ajoutée",
+		MessageDuplicate: "Déjà là",
+		MessageRemoved:   "Retirée",
+		MessageMoved:     "Déplacée",
+		MessageUndone:    "Annulé",
+	},
+	"de": {
+		MessageAdded:     "Hinzugefügt",
+		MessageDuplicate: "Schon drin",
+		MessageRemoved:   "Entfernt",
+		MessageMoved:     "Verschoben",
+		MessageUndone:    "Rückgängig gemacht",
+	},
+}
+
+// SupportedLanguages lists the languages brief responses are available in
+func SupportedLanguages() []string {
+	var languages []string
+	for language := range briefMessages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Message returns the full spoken message, which is in English, or the short confirmation for
+// key in the user's brief language when they prefer brief responses
+func (s UserSettings) Message(key, full string) string {
+	if s.Verbosity != VerbosityBrief {
+		return full
+	}
+	if message, exists := briefMessages[s.BriefLanguage][key]; exists {
+		return message
+	}
+	return briefMessages["en"][key]
+}
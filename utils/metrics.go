@@ -0,0 +1,347 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Every instance of every function adds its metrics to this Redis hash, so /metrics reports
+// totals across all of them. Fields are series such as `http_requests_total{handler="undo"}`
+const metricsKey = "metrics"
+
+// Upper bounds of the latency histogram buckets, in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricFamily describes a metric for the # HELP and # TYPE lines
+type metricFamily struct {
+	Name string
+	Type string
+	Help string
+}
+
+// Metric families in the order /metrics lists them
+var metricFamilies = []metricFamily{
+	{"http_requests_total", "counter", "Requests handled, by handler and status."},
+	{"http_request_duration_seconds", "histogram", "Time taken to handle requests, by handler and status."},
+	{"spotify_requests_total", "counter", "Requests made to Spotify, by endpoint, method and status."},
+	{"spotify_request_duration_seconds", "histogram", "Time taken by requests to Spotify, by endpoint, method and status."},
+	{"spotify_token_refreshes_total", "counter", "Spotify access token refreshes, by result."},
+	{"redis_connections_opened_total", "counter", "Connections opened to Redis."},
+	{"redis_pool_waits_total", "counter", "Times a request waited for a free Redis connection."},
+	{"redis_pool_wait_seconds_total", "counter", "Time spent waiting for free Redis connections."},
+}
+
+// Metrics recorded by this instance that haven't been written to Redis yet
+var pendingMetrics = struct {
+	sync.Mutex
+	values map[string]float64
+}{values: map[string]float64{}}
+
+// Connection pool for writing metrics. It outlives requests, unlike the pools from InitRedis
+var metricsPool *redis.Pool
+var metricsPoolOnce sync.Once
+
+// Longest a metrics write waits for Redis, since every request flushes its metrics before it
+// returns
+const metricsRedisTimeout = time.Second
+
+// Formats a series name with labels given as name, value pairs
+func metricSeries(name string, labels ...string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	var parts []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return fmt.Sprintf("%s{%s}", name, strings.Join(parts, ","))
+}
+
+// Adds delta to a counter
+func addMetric(delta float64, name string, labels ...string) {
+	pendingMetrics.Lock()
+	defer pendingMetrics.Unlock()
+	pendingMetrics.values[metricSeries(name, labels...)] += delta
+}
+
+// Records a latency in a histogram
+func observeLatency(duration time.Duration, name string, labels ...string) {
+	seconds := duration.Seconds()
+	// Limit the capacity so each bucket's labels get their own array
+	labels = labels[:len(labels):len(labels)]
+	for _, bound := range latencyBuckets {
+		if seconds <= bound {
+			addMetric(1, name+"_bucket", append(labels, "le", strconv.FormatFloat(bound, 'g', -1, 64))...)
+		}
+	}
+	addMetric(1, name+"_bucket", append(labels, "le", "+Inf")...)
+	addMetric(seconds, name+"_sum", labels...)
+	addMetric(1, name+"_count", labels...)
+}
+
+// Removes and returns the pending metrics
+func takePendingMetrics() map[string]float64 {
+	pendingMetrics.Lock()
+	defer pendingMetrics.Unlock()
+	values := pendingMetrics.values
+	pendingMetrics.values = map[string]float64{}
+	return values
+}
+
+// Adds metrics that couldn't be written back to the pending ones, so the next flush retries them
+func restorePendingMetrics(values map[string]float64) {
+	pendingMetrics.Lock()
+	defer pendingMetrics.Unlock()
+	for series, value := range values {
+		pendingMetrics.values[series] += value
+	}
+}
+
+// Writes the pending metrics to Redis in one transaction, logging a failure with logger. Without
+// KV_URL, as in tests, they stay pending
+func FlushMetrics(logger *slog.Logger) {
+	metricsPoolOnce.Do(func() {
+		redisURL := os.Getenv("KV_URL")
+		if redisURL == "" {
+			return
+		}
+		metricsPool = &redis.Pool{
+			MaxIdle:     1,
+			IdleTimeout: 4 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(redisURL,
+					redis.DialConnectTimeout(metricsRedisTimeout),
+					redis.DialReadTimeout(metricsRedisTimeout),
+					redis.DialWriteTimeout(metricsRedisTimeout),
+				)
+			},
+			// A function instance can sit frozen between requests, so check a connection that has
+			// been idle before reusing it
+			TestOnBorrow: func(conn redis.Conn, idleSince time.Time) error {
+				if time.Since(idleSince) < time.Minute {
+					return nil
+				}
+				_, err := conn.Do("PING")
+				return err
+			},
+		}
+	})
+	if metricsPool == nil {
+		return
+	}
+
+	err := flushMetrics(metricsPool.Get())
+	if err != nil {
+		logger.Warn("Failed to store metrics", "error", err)
+	}
+}
+
+// Writes the pending metrics, keeping them pending if the write fails
+func flushMetrics(conn redis.Conn) error {
+	values := takePendingMetrics()
+	err := writeMetrics(values, conn)
+	if err != nil {
+		restorePendingMetrics(values)
+	}
+	return err
+}
+
+func writeMetrics(values map[string]float64, conn redis.Conn) error {
+	defer conn.Close()
+
+	if len(values) == 0 {
+		return nil
+	}
+	conn.Send("MULTI")
+	for series, value := range values {
+		conn.Send("HINCRBYFLOAT", metricsKey, series, value)
+	}
+	_, err := conn.Do("EXEC")
+	if err != nil {
+		return fmt.Errorf("failed to store metrics: %v", err)
+	}
+
+	return nil
+}
+
+// statusRecorder remembers the status code written to a response, and the request's logger
+// once RequestLogger has made it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	logger *slog.Logger
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+// TrackRequest records a handler's request count and latency, by status, when the returned
+// function is called, and writes the request's metrics to Redis. Handlers start with
+//
+//	w, done := utils.TrackRequest("add-song", w)
+//	defer done()
+func TrackRequest(handler string, w http.ResponseWriter) (http.ResponseWriter, func()) {
+	return trackRequest(handler, w, true)
+}
+
+// TrackProbe records a health check like TrackRequest, but leaves its metrics for the next
+// request to write, so that a probe never waits on Redis
+func TrackProbe(handler string, w http.ResponseWriter) (http.ResponseWriter, func()) {
+	return trackRequest(handler, w, false)
+}
+
+func trackRequest(handler string, w http.ResponseWriter, flush bool) (http.ResponseWriter, func()) {
+	start := time.Now()
+	// InitRedis replaces the pool, so a different one at the end belongs to this request
+	pool := redisPool
+	recorder := &statusRecorder{ResponseWriter: w}
+	return recorder, func() {
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := []string{"handler", handler, "status", strconv.Itoa(status)}
+		addMetric(1, "http_requests_total", labels...)
+		observeLatency(time.Since(start), "http_request_duration_seconds", labels...)
+		if redisPool != nil && redisPool != pool {
+			stats := redisPool.Stats()
+			addMetric(float64(stats.WaitCount), "redis_pool_waits_total")
+			addMetric(stats.WaitDuration.Seconds(), "redis_pool_wait_seconds_total")
+		}
+		if !flush {
+			return
+		}
+		logger := recorder.logger
+		if logger == nil {
+			logger = slog.Default().With("endpoint", handler)
+		}
+		FlushMetrics(logger)
+	}
+}
+
+// metricsTransport records the count and latency of requests to Spotify
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	labels := []string{"endpoint", spotifyEndpoint(req), "method", req.Method, "status", status}
+	addMetric(1, "spotify_requests_total", labels...)
+	observeLatency(time.Since(start), "spotify_request_duration_seconds", labels...)
+
+	return resp, err
+}
+
+// spotifyClient makes every request to Spotify, so they are all counted
+var spotifyClient = &http.Client{Transport: metricsTransport{next: http.DefaultTransport}}
+
+// Segments that are followed by an ID in Spotify's API paths
+var spotifyIDParents = map[string]bool{"playlists": true, "users": true, "artists": true, "albums": true, "tracks": true}
+
+// Labels a request by its path with IDs replaced, e.g. "/v1/playlists/{id}/tracks", so that
+// the number of series stays small
+func spotifyEndpoint(req *http.Request) string {
+	segments := strings.Split(req.URL.Path, "/")
+	for i := 1; i < len(segments); i++ {
+		if spotifyIDParents[segments[i-1]] && segments[i] != "" {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Retrieves the metrics of every instance and formats them in the Prometheus text format
+func GetMetricsText(conn redis.Conn) (string, error) {
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", metricsKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve metrics: %v", err)
+	}
+
+	return formatMetrics(values), nil
+}
+
+func formatMetrics(values map[string]string) string {
+	bySeries := map[string][]string{}
+	for series := range values {
+		family := metricFamilyName(series)
+		bySeries[family] = append(bySeries[family], series)
+	}
+
+	var text strings.Builder
+	for _, family := range metricFamilies {
+		series := bySeries[family.Name]
+		sort.Slice(series, func(i, j int) bool {
+			return metricSortKey(series[i]) < metricSortKey(series[j])
+		})
+		fmt.Fprintf(&text, "# HELP %s %s\n# TYPE %s %s\n", family.Name, family.Help, family.Name, family.Type)
+		for _, s := range series {
+			fmt.Fprintf(&text, "%s %s\n", s, values[s])
+		}
+	}
+	return text.String()
+}
+
+// Returns the family a series belongs to, e.g. "http_request_duration_seconds" for its buckets
+func metricFamilyName(series string) string {
+	name, _, _ := strings.Cut(series, "{")
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		base := strings.TrimSuffix(name, suffix)
+		for _, family := range metricFamilies {
+			if family.Type == "histogram" && family.Name == base {
+				return base
+			}
+		}
+	}
+	return name
+}
+
+// Orders the series of a histogram by labels, then buckets by bound, then sum and count
+func metricSortKey(series string) string {
+	name, labels, _ := strings.Cut(series, "{")
+	le := ""
+	if i := strings.Index(labels, `le="`); i >= 0 {
+		bound := strings.TrimSuffix(labels[i+4:], `"}`)
+		labels = strings.TrimSuffix(labels[:i], ",") + "}"
+		le = "~" // +Inf sorts last
+		if value, err := strconv.ParseFloat(bound, 64); err == nil && bound != "+Inf" {
+			le = fmt.Sprintf("%020.6f", value)
+		}
+	}
+	suffix := "0"
+	if strings.HasSuffix(name, "_sum") {
+		suffix = "1"
+	} else if strings.HasSuffix(name, "_count") {
+		suffix = "2"
+	}
+	return labels + "\x00" + suffix + le
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackRequest_RecordsStatus(t *testing.T) {
+	takePendingMetrics()
+
+	w, done := TrackRequest("undo", httptest.NewRecorder())
+	http.Error(w, "Missing API Key", http.StatusUnauthorized)
+	done()
+
+	values := takePendingMetrics()
+	assert.Equal(t, 1.0, values[`http_requests_total{handler="undo",status="401"}`])
+	assert.Equal(t, 1.0, values[`http_request_duration_seconds_bucket{handler="undo",status="401",le="+Inf"}`])
+	assert.Equal(t, 1.0, values[`http_request_duration_seconds_count{handler="undo",status="401"}`])
+}
+
+func TestSpotifyEndpoint(t *testing.T) {
+	for path, expected := range map[string]string{
+		"https://api.spotify.com/v1/playlists/37i9dQZF1DXcBWIGoYBM5M/tracks?limit=100": "/v1/playlists/{id}/tracks",
+		"https://api.spotify.com/v1/users/alice/playlists":                             "/v1/users/{id}/playlists",
+		"https://api.spotify.com/v1/tracks?ids=1,2":                                    "/v1/tracks",
+		"https://api.spotify.com/v1/me/player/recently-played":                         "/v1/me/player/recently-played",
+		"https://accounts.spotify.com/api/token":                                       "/api/token",
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		assert.Equal(t, expected, spotifyEndpoint(req), path)
+	}
+}
+
+func TestMetricsText(t *testing.T) {
+	takePendingMetrics()
+	mock := &mockConn{data: map[string][]byte{}}
+
+	observeLatency(20*time.Millisecond, "spotify_request_duration_seconds", "endpoint", "/v1/me/player", "method", "GET", "status", "200")
+	addMetric(1, "spotify_token_refreshes_total", "result", "failure")
+	require.NoError(t, writeMetrics(takePendingMetrics(), mock))
+	addMetric(1, "spotify_token_refreshes_total", "result", "failure")
+	require.NoError(t, writeMetrics(takePendingMetrics(), mock))
+
+	text, err := GetMetricsText(mock)
+	require.NoError(t, err)
+	assert.Contains(t, text, "# TYPE spotify_request_duration_seconds histogram\n")
+	assert.Contains(t, text, `spotify_token_refreshes_total{result="failure"} 2`+"\n")
+
+	// Buckets are in order of their bounds, followed by the sum and count
+	series := `{endpoint="/v1/me/player",method="GET",status="200"`
+	bucket := strings.Index(text, `spotify_request_duration_seconds_bucket`+series+`,le="0.025"} 1`)
+	bigBucket := strings.Index(text, `spotify_request_duration_seconds_bucket`+series+`,le="10"} 1`)
+	infBucket := strings.Index(text, `spotify_request_duration_seconds_bucket`+series+`,le="+Inf"} 1`)
+	count := strings.Index(text, `spotify_request_duration_seconds_count`+series+`} 1`)
+	assert.NotContains(t, text, `le="0.01"}`)
+	require.True(t, bucket >= 0 && bigBucket >= 0 && infBucket >= 0 && count >= 0, text)
+	assert.True(t, bucket < bigBucket && bigBucket < infBucket && infBucket < count, text)
+}
+
+func TestFlushMetrics_KeepsValuesOnFailure(t *testing.T) {
+	takePendingMetrics()
+	mock := &mockConn{data: map[string][]byte{}}
+
+	addMetric(1, "redis_connections_opened_total")
+	assert.Error(t, flushMetrics(&errorConn{mockConn: &mockConn{}}))
+
+	// The next flush writes what the failed one couldn't, along with anything recorded since
+	addMetric(1, "redis_connections_opened_total")
+	require.NoError(t, flushMetrics(mock))
+	assert.Equal(t, "2", mock.hashes[metricsKey]["redis_connections_opened_total"])
+	assert.Empty(t, takePendingMetrics())
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Retention of each user's listening archive: plays older than maxPlayAge are dropped, as are
+// the oldest plays beyond maxArchivedPlays
+const (
+	maxArchivedPlays = 20000
+	maxPlayAge       = 365 * 24 * time.Hour
+)
+
+// Play is a track the user listened to, stored in their listening archive
+type Play struct {
+	PlayedAt time.Time `json:"played_at"`
+	Track    string    `json:"track"`
+	Name     string    `json:"name"`
+	Artists  []string  `json:"artists"`
+	// ContextURI is what the track was playing from, if anything
+	ContextURI string `json:"context_uri,omitempty"`
+}
+
+// NewPlay converts a recently played item for the archive
+func NewPlay(item RecentlyPlayedItem) Play {
+	play := Play{PlayedAt: item.PlayedAt.UTC(), Track: item.Track.URI, Name: item.Track.Name, Artists: []string{}}
+	for _, artist := range item.Track.Artists {
+		play.Artists = append(play.Artists, artist.Name)
+	}
+	if item.Context != nil {
+		play.ContextURI = item.Context.URI
+	}
+	return play
+}
+
+// Describes the play for speech, e.g. "Yellow by Coldplay"
+func (p Play) Describe() string {
+	if len(p.Artists) == 0 {
+		return p.Name
+	}
+	return fmt.Sprintf("%s by %s", p.Name, JoinNames(p.Artists))
+}
+
+// Adds plays to a user's listening archive and applies its retention limits. A play is stored
+// once however often it is added, so overlapping backfills are harmless. Returns the number of
+// plays that were new
+func StorePlays(userID string, plays []Play, conn redis.Conn) (int, error) {
+	defer conn.Close()
+
+	key := fmt.Sprintf("plays:%s", userID)
+	added := 0
+	for _, play := range plays {
+		// Nothing else can have been played at the same moment, so a play already stored at that
+		// time is this one, even if Spotify has since relinked the track or added its context
+		playedAt := play.PlayedAt.UnixMilli()
+		existing, err := redis.Values(conn.Do("ZRANGEBYSCORE", key, playedAt, playedAt, "LIMIT", 0, 1))
+		if err != nil {
+			return added, fmt.Errorf("failed to check for stored play: %v", err)
+		}
+		if len(existing) > 0 {
+			continue
+		}
+
+		data, err := json.Marshal(play)
+		if err != nil {
+			return added, fmt.Errorf("failed to marshal play: %v", err)
+		}
+		count, err := redis.Int(conn.Do("ZADD", key, playedAt, data))
+		if err != nil {
+			return added, fmt.Errorf("failed to store play: %v", err)
+		}
+		added += count
+	}
+
+	_, err := conn.Do("ZREMRANGEBYSCORE", key, "-inf", fmt.Sprintf("(%d", time.Now().Add(-maxPlayAge).UnixMilli()))
+	if err != nil {
+		return added, fmt.Errorf("failed to drop old plays: %v", err)
+	}
+	_, err = conn.Do("ZREMRANGEBYRANK", key, 0, -maxArchivedPlays-1)
+	if err != nil {
+		return added, fmt.Errorf("failed to trim plays: %v", err)
+	}
+
+	return added, nil
+}
+
+// Retrieves a user's plays between from and to, inclusive, newest first. Zero times leave the
+// range open, and a limit of 0 retrieves every play in it
+func GetPlays(userID string, from, to time.Time, limit int, conn redis.Conn) ([]Play, error) {
+	defer conn.Close()
+
+	minScore, maxScore := "-inf", "+inf"
+	if !from.IsZero() {
+		minScore = strconv.FormatInt(from.UnixMilli(), 10)
+	}
+	if !to.IsZero() {
+		maxScore = strconv.FormatInt(to.UnixMilli(), 10)
+	}
+
+	args := redis.Args{fmt.Sprintf("plays:%s", userID), maxScore, minScore}
+	if limit > 0 {
+		args = args.Add("LIMIT", 0, limit)
+	}
+	members, err := redis.ByteSlices(conn.Do("ZREVRANGEBYSCORE", args...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve plays: %v", err)
+	}
+
+	plays := []Play{}
+	for _, member := range members {
+		var play Play
+		err = json.Unmarshal(member, &play)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal play: %v", err)
+		}
+		plays = append(plays, play)
+	}
+
+	return plays, nil
+}
+
+// FilterPlaysByArtist keeps the plays with an artist whose name contains artist, ignoring case
+// and accents
+func FilterPlaysByArtist(plays []Play, artist string) []Play {
+	artist = NormalizeName(artist)
+	filtered := []Play{}
+	for _, play := range plays {
+		for _, name := range play.Artists {
+			if strings.Contains(NormalizeName(name), artist) {
+				filtered = append(filtered, play)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// Retrieves the time of the latest play already archived for a user, or the zero time if none
+func GetPlaysCursor(userID string, conn redis.Conn) (time.Time, error) {
+	defer conn.Close()
+
+	ms, err := redis.Int64(conn.Do("GET", fmt.Sprintf("plays-cursor:%s", userID)))
+	if err == redis.ErrNil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to retrieve plays cursor: %v", err)
+	}
+
+	return time.UnixMilli(ms), nil
+}
+
+// Stores the time of the latest play archived for a user
+func SetPlaysCursor(userID string, playedAt time.Time, conn redis.Conn) error {
+	defer conn.Close()
+
+	_, err := conn.Do("SET", fmt.Sprintf("plays-cursor:%s", userID), strconv.FormatInt(playedAt.UnixMilli(), 10))
+	if err != nil {
+		return fmt.Errorf("failed to store plays cursor: %v", err)
+	}
+
+	return nil
+}
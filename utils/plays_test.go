@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPlay(name, artist string, playedAt time.Time) Play {
+	return Play{PlayedAt: playedAt, Track: "spotify:track:" + name, Name: name, Artists: []string{artist}}
+}
+
+func TestPlays_StoreDedupesAndQueries(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	plays := []Play{
+		testPlay("Yellow", "Coldplay", now.Add(-3*time.Hour)),
+		testPlay("Clocks", "Coldplay", now.Add(-2*time.Hour)),
+		testPlay("Hysteria", "Muse", now.Add(-time.Hour)),
+	}
+
+	added, err := StorePlays("user-1", plays, mock)
+	require.NoError(t, err)
+	assert.Equal(t, 3, added)
+
+	// A backfill overlapping the last one only adds the new play
+	added, err = StorePlays("user-1", append(plays[2:], testPlay("Uprising", "Muse", now)), mock)
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+
+	stored, err := GetPlays("user-1", time.Time{}, time.Time{}, 0, mock)
+	require.NoError(t, err)
+	require.Len(t, stored, 4)
+	assert.Equal(t, "Uprising", stored[0].Name)
+
+	stored, err = GetPlays("user-1", now.Add(-150*time.Minute), now.Add(-time.Hour), 0, mock)
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, "Hysteria", stored[0].Name)
+	assert.Equal(t, "Clocks", stored[1].Name)
+	assert.Equal(t, now.Add(-2*time.Hour), stored[1].PlayedAt)
+
+	stored, err = GetPlays("user-1", time.Time{}, time.Time{}, 2, mock)
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, "Uprising", stored[0].Name)
+	assert.Equal(t, "Hysteria", stored[1].Name)
+}
+
+func TestStorePlays_SameTimeIsSamePlay(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+	playedAt := time.Now().UTC().Truncate(time.Millisecond)
+
+	added, err := StorePlays("user-1", []Play{testPlay("Yellow", "Coldplay", playedAt)}, mock)
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+
+	// The same play, after Spotify relinked the track and reported its context
+	relinked := testPlay("Yellow", "Coldplay", playedAt)
+	relinked.Track = "spotify:track:relinked"
+	relinked.ContextURI = "spotify:playlist:gym"
+	added, err = StorePlays("user-1", []Play{relinked}, mock)
+	require.NoError(t, err)
+	assert.Equal(t, 0, added)
+	assert.Len(t, mock.zsets["plays:user-1"], 1)
+}
+
+func TestFilterPlaysByArtist(t *testing.T) {
+	now := time.Now()
+	plays := []Play{
+		testPlay("Yellow", "Coldplay", now),
+		testPlay("Bésame Mucho", "Andrea Bocelli", now),
+		testPlay("Hysteria", "Muse", now),
+	}
+
+	assert.Len(t, FilterPlaysByArtist(plays, "coldplay"), 1)
+	assert.Equal(t, "Bésame Mucho", FilterPlaysByArtist(plays, "bocelli")[0].Name)
+	assert.Empty(t, FilterPlaysByArtist(plays, "Keane"))
+	assert.Equal(t, "Yellow by Coldplay", plays[0].Describe())
+}
+
+func TestPlaysCursor(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	cursor, err := GetPlaysCursor("user-1", mock)
+	require.NoError(t, err)
+	assert.True(t, cursor.IsZero())
+
+	playedAt := time.UnixMilli(1718000000123)
+	require.NoError(t, SetPlaysCursor("user-1", playedAt, mock))
+	cursor, err = GetPlaysCursor("user-1", mock)
+	require.NoError(t, err)
+	assert.True(t, playedAt.Equal(cursor))
+}
+
+func TestListUserIDs(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{"user:alice": []byte("key-1"), "apiKey:key-1": []byte("{}")}}
+
+	userIDs, err := ListUserIDs(mock)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, userIDs)
+}
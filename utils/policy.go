@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CheckPolicy checks a track against the playlist's content policies. It returns "" when the
+// track may be added, otherwise the rule that blocks it for speech, e.g. "explicit songs aren't
+// allowed"
+func (s PlaylistSettings) CheckPolicy(track Track) string {
+	if s.BlockEpisodes && strings.HasPrefix(track.URI, "spotify:episode:") {
+		return "podcast episodes aren't allowed"
+	}
+	if s.BlockExplicit && track.Explicit {
+		return "explicit songs aren't allowed"
+	}
+	if s.MaxTrackSeconds > 0 && track.DurationMs > s.MaxTrackSeconds*1000 {
+		return fmt.Sprintf("songs longer than %s aren't allowed", describeSeconds(s.MaxTrackSeconds))
+	}
+	for _, artist := range s.BlockedArtists {
+		if name, matched := matchArtist(track, artist); matched {
+			return fmt.Sprintf("songs by %s aren't allowed", name)
+		}
+	}
+	if len(s.AllowedArtists) > 0 {
+		var names []string
+		for _, artist := range s.AllowedArtists {
+			if _, matched := matchArtist(track, artist); matched {
+				return ""
+			}
+			if _, err := ParseSpotifyID(artist, RefTypeArtist); err != nil {
+				names = append(names, artist)
+			}
+		}
+		// Artists given by ID have no name to speak
+		if len(names) != len(s.AllowedArtists) || len(names) > 3 {
+			return "only songs by certain artists are allowed"
+		}
+		return fmt.Sprintf("only songs by %s are allowed", JoinNames(names))
+	}
+	return ""
+}
+
+// Matches an artist given as a name, ID or link against the track's artists, returning the
+// matching artist's name
+func matchArtist(track Track, artist string) (string, bool) {
+	artistID, err := ParseSpotifyID(artist, RefTypeArtist)
+	if err != nil {
+		artistID = ""
+	}
+	name := NormalizeName(artist)
+	for _, trackArtist := range track.Artists {
+		if (artistID != "" && trackArtist.ID == artistID) || (name != "" && NormalizeName(trackArtist.Name) == name) {
+			return trackArtist.Name, true
+		}
+	}
+	return "", false
+}
+
+// Describes a length for speech, e.g. "5 minutes" or "4 minutes 30 seconds"
+func describeSeconds(seconds int) string {
+	minutes, seconds := seconds/60, seconds%60
+	unit := func(n int, name string) string {
+		if n == 1 {
+			return "1 " + name
+		}
+		return fmt.Sprintf("%d %ss", n, name)
+	}
+
+	switch {
+	case minutes == 0:
+		return unit(seconds, "second")
+	case seconds == 0:
+		return unit(minutes, "minute")
+	}
+	return unit(minutes, "minute") + " " + unit(seconds, "second")
+}
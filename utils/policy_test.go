@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	track := *testTrack("a", "Yellow", "Coldplay", "")
+	track.URI = "spotify:track:a"
+	track.Artists[0].ID = "4gzpq5DPGxSnKTe4SA8HAU"
+	track.DurationMs = 269000
+	explicit := track
+	explicit.Explicit = true
+	episode := Track{URI: "spotify:episode:b", Name: "Episode 1"}
+
+	assert.Equal(t, "", PlaylistSettings{}.CheckPolicy(explicit))
+	assert.Equal(t, "", PlaylistSettings{BlockExplicit: true}.CheckPolicy(track))
+	assert.Equal(t, "explicit songs aren't allowed", PlaylistSettings{BlockExplicit: true}.CheckPolicy(explicit))
+	assert.Equal(t, "songs longer than 4 minutes aren't allowed", PlaylistSettings{MaxTrackSeconds: 240}.CheckPolicy(track))
+	assert.Equal(t, "songs longer than 4 minutes 20 seconds aren't allowed", PlaylistSettings{MaxTrackSeconds: 260}.CheckPolicy(track))
+	assert.Equal(t, "", PlaylistSettings{MaxTrackSeconds: 300}.CheckPolicy(track))
+	assert.Equal(t, "podcast episodes aren't allowed", PlaylistSettings{BlockEpisodes: true}.CheckPolicy(episode))
+}
+
+func TestCheckPolicy_Artists(t *testing.T) {
+	track := *testTrack("a", "Yellow", "Coldplay", "")
+	track.Artists[0].ID = "4gzpq5DPGxSnKTe4SA8HAU"
+
+	assert.Equal(t, "songs by Coldplay aren't allowed", PlaylistSettings{BlockedArtists: []string{"coldplay"}}.CheckPolicy(track))
+	assert.Equal(t, "songs by Coldplay aren't allowed", PlaylistSettings{BlockedArtists: []string{"spotify:artist:4gzpq5DPGxSnKTe4SA8HAU"}}.CheckPolicy(track))
+	assert.Equal(t, "", PlaylistSettings{BlockedArtists: []string{"Muse"}}.CheckPolicy(track))
+
+	assert.Equal(t, "", PlaylistSettings{AllowedArtists: []string{"Muse", "Coldplay"}}.CheckPolicy(track))
+	assert.Equal(t, "only songs by Muse and Keane are allowed", PlaylistSettings{AllowedArtists: []string{"Muse", "Keane"}}.CheckPolicy(track))
+	assert.Equal(t, "only songs by certain artists are allowed", PlaylistSettings{AllowedArtists: []string{"12Chz98pHFMPJEknJQMWvI"}}.CheckPolicy(track))
+}
@@ -3,8 +3,10 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -15,7 +17,8 @@ var redisPool *redis.Pool
 func InitRedis() (*redis.Pool, error) {
 	redisURL := os.Getenv("KV_URL")
 	if redisURL == "" {
-		log.Fatal("❌ KV_URL environment variable is not set")
+		slog.Error("KV_URL environment variable is not set")
+		os.Exit(1)
 	}
 
 	redisPool = &redis.Pool{
@@ -25,8 +28,10 @@ func InitRedis() (*redis.Pool, error) {
 		Dial: func() (redis.Conn, error) {
 			c, err := redis.DialURL(redisURL)
 			if err != nil {
-				log.Fatalf("❌ Failed to connect to Redis: %v", err)
+				slog.Error("Failed to connect to Redis", "error", err)
+				os.Exit(1)
 			}
+			addMetric(1, "redis_connections_opened_total")
 			return c, nil
 		},
 	}
@@ -54,8 +59,10 @@ func SetAPIKeyToUserAuthData(apiKey string, token *SpotifyAccessToken, userID st
 	return err
 }
 
-// Retrieves token data using API key
+// Retrieves token data using API key, refreshing the access token if it has expired. logger is
+// the request's, so token refreshes are logged with it
 func GetAPIKeyToUserAuthData(
+	logger *slog.Logger,
 	apiKey string,
 	conn redis.Conn,
 	refreshFn func(refreshToken string) (*SpotifyAccessToken, error),
@@ -81,13 +88,14 @@ func GetAPIKeyToUserAuthData(
 
 	// Check if token is expired
 	if time.Now().After(userAuthData.ExpiresAt) {
-		log.Println("🔄 Access token expired, refreshing...")
+		logger.Info("Access token expired, refreshing", "user_id", userAuthData.UserID)
 
 		// Refresh the token
 		newToken, err := refreshFn(userAuthData.RefreshToken)
 		if err != nil {
 			return nil, fmt.Errorf("failed to refresh token: %v", err)
 		}
+		logger.Debug("Refreshed Spotify access token", "user_id", userAuthData.UserID)
 		// refresh tokens do not change, so keep the existing one
 		newToken.RefreshToken = userAuthData.RefreshToken
 
@@ -135,6 +143,81 @@ func GetUserIDToAPIKey(userID string, conn redis.Conn) (string, error) {
 	return apiKey, nil
 }
 
+// Lists the IDs of every connected user
+func ListUserIDs(conn redis.Conn) ([]string, error) {
+	defer conn.Close()
+
+	return listUserIDs(conn)
+}
+
+func listUserIDs(conn redis.Conn) ([]string, error) {
+	var userIDs []string
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", "user:*", "COUNT", 100))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %v", err)
+		}
+		keys, err := redis.Strings(reply[1], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %v", err)
+		}
+		for _, key := range keys {
+			userIDs = append(userIDs, strings.TrimPrefix(key, "user:"))
+		}
+
+		cursor, err = redis.String(reply[0], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %v", err)
+		}
+		if cursor == "0" {
+			return userIDs, nil
+		}
+	}
+}
+
+// NextUserBatch picks the users a cron job handles in this run, so no run has to get through
+// every user: up to size users in user ID order, continuing after the last user the previous
+// run handled and wrapping around to the first. Records where the next run continues
+func NextUserBatch(job string, size int, conn redis.Conn) ([]string, error) {
+	defer conn.Close()
+
+	userIDs, err := listUserIDs(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	sort.Strings(userIDs)
+
+	key := fmt.Sprintf("cron-cursor:%s", job)
+	last, err := redis.String(conn.Do("GET", key))
+	if err != nil && err != redis.ErrNil {
+		return nil, fmt.Errorf("failed to retrieve cron cursor: %v", err)
+	}
+
+	// The last user may have disconnected since, so start at the first ID after theirs
+	start := sort.SearchStrings(userIDs, last)
+	if start < len(userIDs) && userIDs[start] == last {
+		start++
+	}
+	if size > len(userIDs) {
+		size = len(userIDs)
+	}
+	batch := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		batch = append(batch, userIDs[(start+i)%len(userIDs)])
+	}
+
+	_, err = conn.Do("SET", key, batch[len(batch)-1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to store cron cursor: %v", err)
+	}
+
+	return batch, nil
+}
+
 // DeleteAPIKey removes the API key from Redis
 func DeleteAPIKey(apiKey string, conn redis.Conn) error {
 	defer conn.Close()
@@ -158,3 +241,90 @@ func DeleteUserID(userID string, conn redis.Conn) error {
 
 	return nil
 }
+
+// Normalizes an alias so "Gym", " gym " and "GYM" all refer to the same playlist
+func NormalizeAlias(alias string) string {
+	return strings.ToLower(strings.Join(strings.Fields(alias), " "))
+}
+
+// Retrieves all playlist aliases for a user
+func GetPlaylistAliases(userID string, conn redis.Conn) (map[string]string, error) {
+	defer conn.Close()
+
+	return getStringMap(fmt.Sprintf("aliases:%s", userID), "playlist aliases", conn)
+}
+
+// Returns the playlist ID for an alias, or "" if the alias does not exist
+func GetPlaylistAlias(userID, alias string, conn redis.Conn) (string, error) {
+	defer conn.Close()
+
+	return getStringMapField(fmt.Sprintf("aliases:%s", userID), NormalizeAlias(alias), "playlist alias", conn)
+}
+
+// Creates or updates a playlist alias for a user
+func SetPlaylistAlias(userID, alias, playlistID string, conn redis.Conn) error {
+	defer conn.Close()
+
+	return setStringMapField(fmt.Sprintf("aliases:%s", userID), NormalizeAlias(alias), playlistID, "playlist alias", conn)
+}
+
+// Removes a playlist alias for a user. Returns false if the alias did not exist
+func DeletePlaylistAlias(userID, alias string, conn redis.Conn) (bool, error) {
+	defer conn.Close()
+
+	deleted, err := redis.Int(conn.Do("HDEL", fmt.Sprintf("aliases:%s", userID), NormalizeAlias(alias)))
+	if err != nil {
+		return false, fmt.Errorf("failed to delete playlist alias: %v", err)
+	}
+
+	return deleted > 0, nil
+}
+
+// Returns the ID of the user's fork of a playlist, or "" if they haven't forked it
+func GetPlaylistFork(userID, sourcePlaylistID string, conn redis.Conn) (string, error) {
+	defer conn.Close()
+
+	return getStringMapField(fmt.Sprintf("forks:%s", userID), sourcePlaylistID, "playlist fork", conn)
+}
+
+// Remembers that a user forked a playlist, so later changes go to the fork
+func SetPlaylistFork(userID, sourcePlaylistID, forkPlaylistID string, conn redis.Conn) error {
+	defer conn.Close()
+
+	return setStringMapField(fmt.Sprintf("forks:%s", userID), sourcePlaylistID, forkPlaylistID, "playlist fork", conn)
+}
+
+// Maps of strings, such as a user's aliases, are stored as Redis hashes, so concurrent requests
+// changing different fields don't overwrite each other
+
+// Reads the hash at key. A missing key is an empty map
+func getStringMap(key, description string, conn redis.Conn) (map[string]string, error) {
+	values, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve %s: %v", description, err)
+	}
+
+	return values, nil
+}
+
+// Reads one field of the hash at key, or "" if it isn't set
+func getStringMapField(key, field, description string, conn redis.Conn) (string, error) {
+	value, err := redis.String(conn.Do("HGET", key, field))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve %s: %v", description, err)
+	}
+
+	return value, nil
+}
+
+func setStringMapField(key, field, value, description string, conn redis.Conn) error {
+	_, err := conn.Do("HSET", key, field, value)
+	if err != nil {
+		return fmt.Errorf("failed to store %s: %v", description, err)
+	}
+
+	return nil
+}
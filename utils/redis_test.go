@@ -1,8 +1,13 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,12 +17,25 @@ import (
 )
 
 type mockConn struct {
-	data  map[string][]byte
-	calls []string
+	data    map[string][]byte
+	lists   map[string][][]byte
+	streams map[string][]mockStreamEntry
+	zsets   map[string]map[string]int64
+	hashes  map[string]map[string]string
+	calls   []string
+}
+
+// mockStreamEntry is a stream entry. The mock numbers entries 1000-0, 2000-0, ...
+type mockStreamEntry struct {
+	id     string
+	fields []interface{}
 }
 
 func (m *mockConn) Do(commandName string, args ...interface{}) (reply interface{}, err error) {
 	m.calls = append(m.calls, commandName)
+	if commandName == "PING" {
+		return "PONG", nil
+	}
 	if commandName == "GET" {
 		key := fmt.Sprintf("%v", args[0])
 		if val, ok := m.data[key]; ok {
@@ -39,15 +57,224 @@ func (m *mockConn) Do(commandName string, args ...interface{}) (reply interface{
 	if commandName == "DEL" {
 		key := fmt.Sprintf("%v", args[0])
 		delete(m.data, key)
+		delete(m.lists, key)
 		return nil, nil
 	}
+	if commandName == "LPUSH" {
+		key := fmt.Sprintf("%v", args[0])
+		if m.lists == nil {
+			m.lists = map[string][][]byte{}
+		}
+		m.lists[key] = append([][]byte{args[1].([]byte)}, m.lists[key]...)
+		return int64(len(m.lists[key])), nil
+	}
+	if commandName == "LPOP" {
+		key := fmt.Sprintf("%v", args[0])
+		if len(m.lists[key]) == 0 {
+			return nil, redis.ErrNil
+		}
+		val := m.lists[key][0]
+		m.lists[key] = m.lists[key][1:]
+		return val, nil
+	}
+	if commandName == "LTRIM" {
+		key := fmt.Sprintf("%v", args[0])
+		stop := args[2].(int)
+		if len(m.lists[key]) > stop+1 {
+			m.lists[key] = m.lists[key][:stop+1]
+		}
+		return "OK", nil
+	}
+	if commandName == "LRANGE" {
+		key := fmt.Sprintf("%v", args[0])
+		reply := []interface{}{}
+		for _, val := range m.lists[key] {
+			reply = append(reply, val)
+		}
+		return reply, nil
+	}
+	if commandName == "XADD" {
+		key := fmt.Sprintf("%v", args[0])
+		if m.streams == nil {
+			m.streams = map[string][]mockStreamEntry{}
+		}
+		// Skip the MAXLEN ~ <count> * arguments
+		var fields []interface{}
+		for _, arg := range args[5:] {
+			fields = append(fields, []byte(fmt.Sprintf("%v", arg)))
+		}
+		id := fmt.Sprintf("%d-0", 1000*(len(m.streams[key])+1))
+		m.streams[key] = append(m.streams[key], mockStreamEntry{id: id, fields: fields})
+		return []byte(id), nil
+	}
+	if commandName == "XREVRANGE" {
+		key := fmt.Sprintf("%v", args[0])
+		end, start, count := fmt.Sprintf("%v", args[1]), fmt.Sprintf("%v", args[2]), args[4].(int)
+		// A start without a sequence begins at its first entry
+		if start != "-" && !strings.Contains(start, "-") {
+			start += "-0"
+		}
+		reply := []interface{}{}
+		for i := len(m.streams[key]) - 1; i >= 0 && len(reply) < count; i-- {
+			entry := m.streams[key][i]
+			if (start == "-" || compareStreamIDs(entry.id, start) >= 0) && (end == "+" || compareStreamIDs(entry.id, end) <= 0) {
+				reply = append(reply, []interface{}{[]byte(entry.id), entry.fields})
+			}
+		}
+		return reply, nil
+	}
+	if commandName == "ZADD" {
+		key := fmt.Sprintf("%v", args[0])
+		if m.zsets == nil {
+			m.zsets = map[string]map[string]int64{}
+		}
+		if m.zsets[key] == nil {
+			m.zsets[key] = map[string]int64{}
+		}
+		member := string(args[2].([]byte))
+		_, exists := m.zsets[key][member]
+		m.zsets[key][member] = args[1].(int64)
+		if exists {
+			return int64(0), nil
+		}
+		return int64(1), nil
+	}
+	if commandName == "ZREVRANGEBYSCORE" {
+		key := fmt.Sprintf("%v", args[0])
+		maxScore, minScore := int64(math.MaxInt64), int64(math.MinInt64)
+		if score, err := strconv.ParseInt(fmt.Sprintf("%v", args[1]), 10, 64); err == nil {
+			maxScore = score
+		}
+		if score, err := strconv.ParseInt(fmt.Sprintf("%v", args[2]), 10, 64); err == nil {
+			minScore = score
+		}
+		var members []string
+		for member, score := range m.zsets[key] {
+			if score >= minScore && score <= maxScore {
+				members = append(members, member)
+			}
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return m.zsets[key][members[i]] > m.zsets[key][members[j]]
+		})
+		// Optionally LIMIT 0 count
+		if len(args) == 6 {
+			if count := args[5].(int); len(members) > count {
+				members = members[:count]
+			}
+		}
+		reply := []interface{}{}
+		for _, member := range members {
+			reply = append(reply, []byte(member))
+		}
+		return reply, nil
+	}
+	if commandName == "ZRANGEBYSCORE" {
+		// From "-inf" or a score to a score, with LIMIT 0 count
+		key := fmt.Sprintf("%v", args[0])
+		minScore, maxScore := int64(math.MinInt64), args[2].(int64)
+		if score, ok := args[1].(int64); ok {
+			minScore = score
+		}
+		var members []string
+		for member, score := range m.zsets[key] {
+			if score >= minScore && score <= maxScore {
+				members = append(members, member)
+			}
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return m.zsets[key][members[i]] < m.zsets[key][members[j]]
+		})
+		if count := args[5].(int); len(members) > count {
+			members = members[:count]
+		}
+		reply := []interface{}{}
+		for _, member := range members {
+			reply = append(reply, []byte(member))
+		}
+		return reply, nil
+	}
+	if commandName == "ZREM" {
+		key := fmt.Sprintf("%v", args[0])
+		member := string(args[1].([]byte))
+		if _, exists := m.zsets[key][member]; !exists {
+			return int64(0), nil
+		}
+		delete(m.zsets[key], member)
+		return int64(1), nil
+	}
+	if commandName == "HINCRBYFLOAT" {
+		key, field := fmt.Sprintf("%v", args[0]), fmt.Sprintf("%v", args[1])
+		value, _ := strconv.ParseFloat(m.hash(key)[field], 64)
+		m.hash(key)[field] = strconv.FormatFloat(value+args[2].(float64), 'g', -1, 64)
+		return []byte(m.hash(key)[field]), nil
+	}
+	if commandName == "HSET" {
+		key, field := fmt.Sprintf("%v", args[0]), fmt.Sprintf("%v", args[1])
+		_, exists := m.hash(key)[field]
+		m.hash(key)[field] = fmt.Sprintf("%v", args[2])
+		if exists {
+			return int64(0), nil
+		}
+		return int64(1), nil
+	}
+	if commandName == "HGET" {
+		key, field := fmt.Sprintf("%v", args[0]), fmt.Sprintf("%v", args[1])
+		value, ok := m.hashes[key][field]
+		if !ok {
+			return nil, nil
+		}
+		return []byte(value), nil
+	}
+	if commandName == "HDEL" {
+		key, field := fmt.Sprintf("%v", args[0]), fmt.Sprintf("%v", args[1])
+		if _, exists := m.hashes[key][field]; !exists {
+			return int64(0), nil
+		}
+		delete(m.hashes[key], field)
+		return int64(1), nil
+	}
+	if commandName == "HGETALL" {
+		key := fmt.Sprintf("%v", args[0])
+		reply := []interface{}{}
+		for field, value := range m.hashes[key] {
+			reply = append(reply, []byte(field), []byte(value))
+		}
+		return reply, nil
+	}
+	if commandName == "SCAN" {
+		var keys []interface{}
+		for key := range m.data {
+			if strings.HasPrefix(key, "user:") {
+				keys = append(keys, []byte(key))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}, nil
+	}
 	return nil, nil
 }
-func (m *mockConn) Close() error                      { return nil }
-func (m *mockConn) Err() error                        { return nil }
-func (m *mockConn) Send(string, ...interface{}) error { return nil }
-func (m *mockConn) Flush() error                      { return nil }
-func (m *mockConn) Receive() (interface{}, error)     { return nil, nil }
+
+// Returns the hash at key, creating it if needed
+func (m *mockConn) hash(key string) map[string]string {
+	if m.hashes == nil {
+		m.hashes = map[string]map[string]string{}
+	}
+	if m.hashes[key] == nil {
+		m.hashes[key] = map[string]string{}
+	}
+	return m.hashes[key]
+}
+
+func (m *mockConn) Close() error                  { return nil }
+func (m *mockConn) Err() error                    { return nil }
+func (m *mockConn) Flush() error                  { return nil }
+func (m *mockConn) Receive() (interface{}, error) { return nil, nil }
+
+// Send runs the command right away, so transactions apply as they are queued
+func (m *mockConn) Send(commandName string, args ...interface{}) error {
+	_, err := m.Do(commandName, args...)
+	return err
+}
 
 type mockPool struct{ conn *mockConn }
 
@@ -92,8 +319,12 @@ func TestGetAPIKeyToUserAuthData_ExpiredTokenRefresh(t *testing.T) {
 	}
 
 	// Call function under test
-	result, err := GetAPIKeyToUserAuthData("test-api-key", mock, mockRefresh, mockSet)
+	var logs bytes.Buffer
+	logger := NewLogger(&logs, "text", "").With("request_id", "req-1")
+	result, err := GetAPIKeyToUserAuthData(logger, "test-api-key", mock, mockRefresh, mockSet)
 	require.NoError(t, err)
+	assert.Contains(t, logs.String(), "Access token expired, refreshing")
+	assert.Contains(t, logs.String(), "request_id=req-1")
 	assert.Equal(t, "new-token", result.AccessToken)
 	assert.Equal(t, "refresh-token", result.RefreshToken)
 	assert.Equal(t, "user-123", result.UserID)
@@ -123,6 +354,28 @@ func TestGetUserIDToAPIKey_RedisError(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to retrieve API key by user ID")
 }
 
+func TestNextUserBatch(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{
+		"user:c": []byte("key-c"),
+		"user:a": []byte("key-a"),
+		"user:b": []byte("key-b"),
+	}}
+
+	batch, err := NextUserBatch("backfill-plays", 2, mock)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, batch)
+
+	// The next run continues where this one stopped, wrapping around
+	batch, err = NextUserBatch("backfill-plays", 2, mock)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "a"}, batch)
+
+	// Each job keeps its own place
+	batch, err = NextUserBatch("refresh-smart-playlists", 5, mock)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, batch)
+}
+
 type errorConn struct {
 	*mockConn
 }
@@ -197,3 +450,65 @@ func TestDeleteUserID_Error(t *testing.T) {
 	err := DeleteUserID("user-1", errConn)
 	assert.Error(t, err)
 }
+
+func TestPlaylistAliases_SetGetDelete(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	err := SetPlaylistAlias("user-1", " Gym ", "playlist-123", mock)
+	require.NoError(t, err)
+
+	playlistID, err := GetPlaylistAlias("user-1", "GYM", mock)
+	assert.NoError(t, err)
+	assert.Equal(t, "playlist-123", playlistID)
+
+	aliases, err := GetPlaylistAliases("user-1", mock)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"gym": "playlist-123"}, aliases)
+
+	deleted, err := DeletePlaylistAlias("user-1", "gym", mock)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+
+	playlistID, err = GetPlaylistAlias("user-1", "gym", mock)
+	assert.NoError(t, err)
+	assert.Equal(t, "", playlistID)
+}
+
+func TestSetPlaylistAlias_WritesOnlyItsField(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	require.NoError(t, SetPlaylistAlias("user-1", "gym", "playlist-1", mock))
+	require.NoError(t, SetPlaylistAlias("user-1", "chill", "playlist-2", mock))
+
+	// No read-modify-write, so concurrent requests can't drop each other's aliases
+	assert.Equal(t, []string{"HSET", "HSET"}, mock.calls)
+	assert.Equal(t, map[string]string{"gym": "playlist-1", "chill": "playlist-2"}, mock.hashes["aliases:user-1"])
+}
+
+func TestDeletePlaylistAlias_NotFound(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+	deleted, err := DeletePlaylistAlias("user-1", "gym", mock)
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestGetPlaylistAliases_RedisError(t *testing.T) {
+	errConn := &errorConn{mockConn: &mockConn{data: map[string][]byte{}}}
+	_, err := GetPlaylistAliases("user-1", errConn)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to retrieve playlist aliases")
+}
+
+func TestPlaylistFork_SetGet(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	forkID, err := GetPlaylistFork("user-1", "source", mock)
+	assert.NoError(t, err)
+	assert.Equal(t, "", forkID)
+
+	require.NoError(t, SetPlaylistFork("user-1", "source", "fork", mock))
+
+	forkID, err = GetPlaylistFork("user-1", "source", mock)
+	assert.NoError(t, err)
+	assert.Equal(t, "fork", forkID)
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Spotify object types that can be referenced by URL, URI or ID
+const (
+	RefTypePlaylist = "playlist"
+	RefTypeTrack    = "track"
+	RefTypeAlbum    = "album"
+	RefTypeArtist   = "artist"
+	RefTypeEpisode  = "episode"
+)
+
+const spotifyIDLength = 22
+
+// SpotifyRef is a normalized reference to a Spotify object
+type SpotifyRef struct {
+	Type string
+	ID   string
+}
+
+// URI returns the reference as a spotify: URI, e.g. spotify:track:4uLU6hMCjMI75M1A2tKUQC
+func (r SpotifyRef) URI() string {
+	return fmt.Sprintf("spotify:%s:%s", r.Type, r.ID)
+}
+
+// ParseSpotifyRef normalizes an open.spotify.com link, a spotify: URI or a bare ID.
+// A bare ID carries no type, so it is assigned the given defaultType.
+func ParseSpotifyRef(ref, defaultType string) (SpotifyRef, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return SpotifyRef{}, fmt.Errorf("empty Spotify reference")
+	}
+
+	var refType, id string
+	switch {
+	case strings.HasPrefix(ref, "spotify:"):
+		// spotify:track:<id>, or the legacy spotify:user:<user>:playlist:<id>
+		parts := strings.Split(ref, ":")
+		if len(parts) < 3 {
+			return SpotifyRef{}, fmt.Errorf("invalid Spotify URI: %s", ref)
+		}
+		refType, id = parts[len(parts)-2], parts[len(parts)-1]
+
+	case strings.Contains(ref, "open.spotify.com"):
+		if !strings.Contains(ref, "://") {
+			ref = "https://" + ref
+		}
+		u, err := url.Parse(ref)
+		if err != nil || u.Host != "open.spotify.com" {
+			return SpotifyRef{}, fmt.Errorf("invalid Spotify URL: %s", ref)
+		}
+
+		// Drop locale prefixes such as /intl-de/ and legacy /user/<user>/ segments
+		segments := strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' })
+		if len(segments) > 0 && strings.HasPrefix(segments[0], "intl-") {
+			segments = segments[1:]
+		}
+		if len(segments) < 2 {
+			return SpotifyRef{}, fmt.Errorf("invalid Spotify URL: %s", ref)
+		}
+		refType, id = segments[len(segments)-2], segments[len(segments)-1]
+
+	default:
+		refType, id = defaultType, ref
+	}
+
+	switch refType {
+	case RefTypePlaylist, RefTypeTrack, RefTypeAlbum, RefTypeArtist, RefTypeEpisode:
+	default:
+		return SpotifyRef{}, fmt.Errorf("unsupported Spotify reference type %q", refType)
+	}
+
+	if !isSpotifyID(id) {
+		return SpotifyRef{}, fmt.Errorf("invalid Spotify ID %q", id)
+	}
+
+	return SpotifyRef{Type: refType, ID: id}, nil
+}
+
+// ParseSpotifyID parses a reference and returns its ID, failing if it refers to a different
+// type of object, e.g. a track link where a playlist was expected
+func ParseSpotifyID(ref, refType string) (string, error) {
+	parsed, err := ParseSpotifyRef(ref, refType)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Type != refType {
+		return "", fmt.Errorf("expected a %s but got a %s", refType, parsed.Type)
+	}
+
+	return parsed.ID, nil
+}
+
+// Spotify IDs are 22 base62 characters
+func isSpotifyID(id string) bool {
+	if len(id) != spotifyIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
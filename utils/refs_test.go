@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpotifyRef_Formats(t *testing.T) {
+	const id = "37i9dQZF1DXcBWIGoYBM5M"
+	cases := map[string]SpotifyRef{
+		id:                                    {Type: RefTypePlaylist, ID: id},
+		"spotify:playlist:" + id:              {Type: RefTypePlaylist, ID: id},
+		"spotify:user:someone:playlist:" + id: {Type: RefTypePlaylist, ID: id},
+		"https://open.spotify.com/playlist/" + id + "?si=abc":      {Type: RefTypePlaylist, ID: id},
+		"https://open.spotify.com/intl-de/track/" + id:             {Type: RefTypeTrack, ID: id},
+		"open.spotify.com/album/" + id:                             {Type: RefTypeAlbum, ID: id},
+		"https://open.spotify.com/user/someone/playlist/" + id:     {Type: RefTypePlaylist, ID: id},
+		"  spotify:episode:" + id + " ":                            {Type: RefTypeEpisode, ID: id},
+		"https://open.spotify.com/artist/" + id + "?si=x&nd=1#top": {Type: RefTypeArtist, ID: id},
+	}
+
+	for input, expected := range cases {
+		ref, err := ParseSpotifyRef(input, RefTypePlaylist)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, ref, input)
+	}
+}
+
+func TestParseSpotifyRef_Invalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"gym",
+		"spotify:show:37i9dQZF1DXcBWIGoYBM5M",
+		"https://open.spotify.com/",
+		"https://example.com/playlist/37i9dQZF1DXcBWIGoYBM5M",
+		"spotify:track:not-a-valid-id",
+	} {
+		_, err := ParseSpotifyRef(input, RefTypePlaylist)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseSpotifyID_WrongType(t *testing.T) {
+	_, err := ParseSpotifyID("spotify:track:4uLU6hMCjMI75M1A2tKUQC", RefTypePlaylist)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "expected a playlist")
+}
+
+func TestSpotifyRef_URI(t *testing.T) {
+	ref := SpotifyRef{Type: RefTypeTrack, ID: "4uLU6hMCjMI75M1A2tKUQC"}
+	assert.Equal(t, "spotify:track:4uLU6hMCjMI75M1A2tKUQC", ref.URI())
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// StringList is a JSON field that accepts either a single string or a list of strings,
+// so Shortcuts can send one value or many without changing the request shape
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = StringList{}
+		if single = strings.TrimSpace(single); single != "" {
+			*l = append(*l, single)
+		}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = StringList{}
+	for _, value := range list {
+		if value = strings.TrimSpace(value); value != "" {
+			*l = append(*l, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringList_UnmarshalJSON(t *testing.T) {
+	var body struct {
+		Track StringList `json:"track"`
+	}
+
+	require.NoError(t, json.Unmarshal([]byte(`{"track": "spotify:track:a"}`), &body))
+	assert.Equal(t, StringList{"spotify:track:a"}, body.Track)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"track": " road trip "}`), &body))
+	assert.Equal(t, StringList{"road trip"}, body.Track)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"track": ""}`), &body))
+	assert.Empty(t, body.Track)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"track": ["spotify:track:a", " ", "spotify:track:b"]}`), &body))
+	assert.Equal(t, StringList{"spotify:track:a", "spotify:track:b"}, body.Track)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"track": 42}`), &body))
+}
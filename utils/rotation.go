@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"sort"
+)
+
+// PlanRotation returns the positions to remove so the playlist fits the limits in settings,
+// oldest-added first. Positions in keep, such as tracks that were just added, are never removed.
+// The positions are returned in ascending order
+func PlanRotation(items []PlaylistItem, settings PlaylistSettings, keep map[int]bool) []int {
+	count, durationMs := len(items), 0
+	for _, item := range items {
+		if item.Track != nil {
+			durationMs += item.Track.DurationMs
+		}
+	}
+	overLimit := func() bool {
+		return (settings.MaxTracks > 0 && count > settings.MaxTracks) ||
+			(settings.MaxDurationMinutes > 0 && durationMs > settings.MaxDurationMinutes*60*1000)
+	}
+
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return items[order[i]].AddedAt.Before(items[order[j]].AddedAt)
+	})
+
+	var positions []int
+	for _, position := range order {
+		if !overLimit() {
+			break
+		}
+		// Unavailable items can't be referred to, so they can't be removed
+		if keep[position] || items[position].Track == nil {
+			continue
+		}
+		positions = append(positions, position)
+		count--
+		durationMs -= items[position].Track.DurationMs
+	}
+
+	sort.Ints(positions)
+	return positions
+}
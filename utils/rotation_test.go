@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rotationItems(durationsMs ...int) []PlaylistItem {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var items []PlaylistItem
+	for i, durationMs := range durationsMs {
+		items = append(items, PlaylistItem{
+			Track:   &Track{ID: string(rune('a' + i)), DurationMs: durationMs},
+			AddedAt: start.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	return items
+}
+
+func TestPlanRotation_MaxTracks(t *testing.T) {
+	items := rotationItems(1000, 1000, 1000, 1000, 1000)
+	// The newest track was inserted at the top
+	items[0].AddedAt, items[4].AddedAt = items[4].AddedAt, items[0].AddedAt
+
+	assert.Equal(t, []int{1, 4}, PlanRotation(items, PlaylistSettings{MaxTracks: 3}, nil))
+	assert.Equal(t, []int{1, 2}, PlanRotation(items, PlaylistSettings{MaxTracks: 3}, map[int]bool{4: true}))
+	assert.Empty(t, PlanRotation(items, PlaylistSettings{MaxTracks: 5}, nil))
+	assert.Empty(t, PlanRotation(items, PlaylistSettings{}, nil))
+}
+
+func TestPlanRotation_MaxDuration(t *testing.T) {
+	minute := 60 * 1000
+	items := rotationItems(4*minute, 3*minute, 3*minute, 3*minute)
+
+	assert.Equal(t, []int{0}, PlanRotation(items, PlaylistSettings{MaxDurationMinutes: 10}, nil))
+	assert.Equal(t, []int{0, 1}, PlanRotation(items, PlaylistSettings{MaxDurationMinutes: 10, MaxTracks: 2}, nil))
+}
+
+func TestPlanRotation_SkipsUnavailableItems(t *testing.T) {
+	items := rotationItems(1000, 1000, 1000)
+	items[0].Track = nil
+
+	assert.Equal(t, []int{1}, PlanRotation(items, PlaylistSettings{MaxTracks: 2}, nil))
+}
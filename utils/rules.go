@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RoutingRule picks the playlist add-song uses when no playlist is given. A rule fires when every
+// condition it sets matches; conditions left empty match anything
+type RoutingRule struct {
+	Name       string `json:"name,omitempty"`
+	PlaylistID string `json:"playlist_id"`
+
+	// Genres matches when any of the song's artists has a genre containing one of these, e.g.
+	// "jazz" matches "smooth jazz"
+	Genres []string `json:"genres,omitempty"`
+	// StartTime and EndTime are "HH:MM" and match from StartTime up to EndTime, wrapping past
+	// midnight when EndTime is earlier
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+	// Days are weekday names such as "monday", or "weekday" and "weekend"
+	Days []string `json:"days,omitempty"`
+	// Devices are device names, e.g. "Kitchen", or types, e.g. "smartphone"
+	Devices []string `json:"devices,omitempty"`
+	// Contexts are context types, e.g. "album" or "collection" for Liked Songs, or links to
+	// what is playing, e.g. a playlist
+	Contexts []string `json:"contexts,omitempty"`
+}
+
+// RuleContext is what routing rules are evaluated against
+type RuleContext struct {
+	Now time.Time
+	// Genres are the genres of the song's artists
+	Genres      []string
+	DeviceName  string
+	DeviceType  string
+	ContextType string
+	ContextURI  string
+}
+
+// Label names the rule for speech, falling back to its position in the list
+func (r RoutingRule) Label(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("rule %d", index+1)
+}
+
+// NeedsGenres reports whether evaluating the rule requires the song's genres, which cost an
+// extra request
+func (r RoutingRule) NeedsGenres() bool {
+	return len(r.Genres) > 0
+}
+
+// Matches reports whether every condition of the rule holds
+func (r RoutingRule) Matches(ctx RuleContext) bool {
+	if len(r.Genres) > 0 && !matchesGenre(r.Genres, ctx.Genres) {
+		return false
+	}
+	if r.StartTime != "" && !matchesTime(r.StartTime, r.EndTime, ctx.Now) {
+		return false
+	}
+	if len(r.Days) > 0 && !matchesDay(r.Days, ctx.Now.Weekday()) {
+		return false
+	}
+	if len(r.Devices) > 0 && !matchesDevice(r.Devices, ctx.DeviceName, ctx.DeviceType) {
+		return false
+	}
+	if len(r.Contexts) > 0 && !matchesContext(r.Contexts, ctx.ContextType, ctx.ContextURI) {
+		return false
+	}
+	return true
+}
+
+// MatchRule returns the index of the first rule that matches, or -1 if none does
+func MatchRule(rules []RoutingRule, ctx RuleContext) int {
+	for i, rule := range rules {
+		if rule.Matches(ctx) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Validate checks that the rule has a destination and well-formed conditions
+func (r RoutingRule) Validate() error {
+	if id, err := ParseSpotifyID(r.PlaylistID, RefTypePlaylist); err != nil || id != r.PlaylistID {
+		return fmt.Errorf("invalid playlist_id %q in rule %q: use a playlist ID", r.PlaylistID, r.Name)
+	}
+	if (r.StartTime == "") != (r.EndTime == "") {
+		return fmt.Errorf("rule %q needs both start_time and end_time", r.Name)
+	}
+	for _, clock := range []string{r.StartTime, r.EndTime} {
+		if _, err := parseClock(clock); clock != "" && err != nil {
+			return fmt.Errorf("invalid time %q in rule %q: use HH:MM", clock, r.Name)
+		}
+	}
+	for _, day := range r.Days {
+		if _, exists := ruleDays[strings.ToLower(day)]; !exists {
+			return fmt.Errorf("invalid day %q in rule %q: use a weekday name, \"weekday\" or \"weekend\"", day, r.Name)
+		}
+	}
+	return nil
+}
+
+var ruleDays = map[string][]time.Weekday{
+	"sunday":    {time.Sunday},
+	"monday":    {time.Monday},
+	"tuesday":   {time.Tuesday},
+	"wednesday": {time.Wednesday},
+	"thursday":  {time.Thursday},
+	"friday":    {time.Friday},
+	"saturday":  {time.Saturday},
+	"weekday":   {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend":   {time.Saturday, time.Sunday},
+}
+
+// Parses "HH:MM" into minutes since midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func matchesGenre(ruleGenres, genres []string) bool {
+	for _, ruleGenre := range ruleGenres {
+		for _, genre := range genres {
+			if strings.Contains(NormalizeName(genre), NormalizeName(ruleGenre)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesTime(start, end string, now time.Time) bool {
+	startMinutes, err := parseClock(start)
+	if err != nil {
+		return false
+	}
+	endMinutes, err := parseClock(end)
+	if err != nil {
+		return false
+	}
+
+	minutes := now.Hour()*60 + now.Minute()
+	if startMinutes <= endMinutes {
+		return minutes >= startMinutes && minutes < endMinutes
+	}
+	return minutes >= startMinutes || minutes < endMinutes
+}
+
+func matchesDay(days []string, weekday time.Weekday) bool {
+	for _, day := range days {
+		for _, ruleDay := range ruleDays[strings.ToLower(day)] {
+			if ruleDay == weekday {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesDevice(devices []string, name, deviceType string) bool {
+	for _, device := range devices {
+		device = NormalizeName(device)
+		if device != "" && (device == NormalizeName(name) || device == NormalizeName(deviceType)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesContext(contexts []string, contextType, contextURI string) bool {
+	playing, err := ParseSpotifyRef(contextURI, "")
+	for _, context := range contexts {
+		if strings.EqualFold(context, contextType) {
+			return true
+		}
+		if ref, refErr := ParseSpotifyRef(context, ""); refErr == nil && err == nil && ref == playing {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingRule_Matches(t *testing.T) {
+	// A Saturday evening
+	ctx := RuleContext{
+		Now:         time.Date(2024, 6, 8, 21, 30, 0, 0, time.UTC),
+		Genres:      []string{"modern rock", "permanent wave"},
+		DeviceName:  "Kitchen",
+		DeviceType:  "Speaker",
+		ContextType: "playlist",
+		ContextURI:  "spotify:playlist:37i9dQZF1DXcBWIGoYBM5M",
+	}
+
+	assert.True(t, RoutingRule{}.Matches(ctx))
+	assert.True(t, RoutingRule{Genres: []string{"jazz", "Rock"}}.Matches(ctx))
+	assert.False(t, RoutingRule{Genres: []string{"jazz"}}.Matches(ctx))
+	assert.True(t, RoutingRule{StartTime: "21:00", EndTime: "23:00"}.Matches(ctx))
+	assert.True(t, RoutingRule{StartTime: "20:00", EndTime: "02:00"}.Matches(ctx))
+	assert.False(t, RoutingRule{StartTime: "06:00", EndTime: "21:30"}.Matches(ctx))
+	assert.True(t, RoutingRule{Days: []string{"Weekend"}}.Matches(ctx))
+	assert.False(t, RoutingRule{Days: []string{"weekday", "sunday"}}.Matches(ctx))
+	assert.True(t, RoutingRule{Devices: []string{"kitchen"}}.Matches(ctx))
+	assert.True(t, RoutingRule{Devices: []string{"speaker"}}.Matches(ctx))
+	assert.False(t, RoutingRule{Devices: []string{"smartphone"}}.Matches(ctx))
+	assert.True(t, RoutingRule{Contexts: []string{"https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M"}}.Matches(ctx))
+	assert.True(t, RoutingRule{Contexts: []string{"album", "playlist"}}.Matches(ctx))
+	assert.False(t, RoutingRule{Contexts: []string{"collection"}}.Matches(ctx))
+	assert.False(t, RoutingRule{Genres: []string{"rock"}, Devices: []string{"Car"}}.Matches(ctx))
+}
+
+func TestMatchRule(t *testing.T) {
+	rules := []RoutingRule{
+		{Name: "Jazz", Genres: []string{"jazz"}},
+		{Devices: []string{"Kitchen"}},
+		{Name: "Everything else"},
+	}
+
+	assert.Equal(t, 1, MatchRule(rules, RuleContext{DeviceName: "Kitchen"}))
+	assert.Equal(t, 2, MatchRule(rules, RuleContext{}))
+	assert.Equal(t, -1, MatchRule(rules[:1], RuleContext{}))
+	assert.Equal(t, "rule 2", rules[1].Label(1))
+	assert.Equal(t, "Jazz", rules[0].Label(0))
+}
+
+func TestRoutingRule_Validate(t *testing.T) {
+	playlistID := "37i9dQZF1DXcBWIGoYBM5M"
+
+	assert.NoError(t, RoutingRule{PlaylistID: playlistID, StartTime: "22:00", EndTime: "06:00", Days: []string{"Friday"}}.Validate())
+	assert.Error(t, RoutingRule{PlaylistID: "gym"}.Validate())
+	assert.Error(t, RoutingRule{PlaylistID: playlistID, StartTime: "22:00"}.Validate())
+	assert.Error(t, RoutingRule{PlaylistID: playlistID, StartTime: "10pm", EndTime: "06:00"}.Validate())
+	assert.Error(t, RoutingRule{PlaylistID: playlistID, Days: []string{"someday"}}.Validate())
+}
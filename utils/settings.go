@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// Embedded so time zones load even where the system has no zoneinfo
+	_ "time/tzdata"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// What add-song does when a track is already in the playlist
+const (
+	DuplicatesSkip      = "skip"
+	DuplicatesAllow     = "allow"
+	DuplicatesMoveToTop = "move_to_top"
+)
+
+// How much add-song, remove-song, move-song and undo say in their spoken responses
+const (
+	VerbosityNormal = "normal"
+	// VerbosityBrief answers with a short confirmation such as "Added"
+	VerbosityBrief = "brief"
+)
+
+// InsertPosition is where add-song inserts tracks: an index into the playlist, or InsertAtBottom.
+// In JSON it is "top", "bottom" or an index
+type InsertPosition int
+
+const (
+	InsertAtTop    InsertPosition = 0
+	InsertAtBottom InsertPosition = -1
+)
+
+func (p *InsertPosition) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		switch name {
+		case "top":
+			*p = InsertAtTop
+		case "bottom":
+			*p = InsertAtBottom
+		default:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 {
+				return fmt.Errorf("invalid position %q: use \"top\", \"bottom\" or an index", name)
+			}
+			*p = InsertPosition(index)
+		}
+		return nil
+	}
+
+	var index int
+	if err := json.Unmarshal(data, &index); err != nil || index < 0 {
+		return fmt.Errorf("invalid position %s: use \"top\", \"bottom\" or an index", data)
+	}
+	*p = InsertPosition(index)
+	return nil
+}
+
+func (p InsertPosition) MarshalJSON() ([]byte, error) {
+	switch p {
+	case InsertAtTop:
+		return json.Marshal("top")
+	case InsertAtBottom:
+		return json.Marshal("bottom")
+	}
+	return json.Marshal(int(p))
+}
+
+// Describes the position for speech, e.g. "the top of"
+func (p InsertPosition) Describe() string {
+	switch p {
+	case InsertAtTop:
+		return "the top of"
+	case InsertAtBottom:
+		return "the end of"
+	}
+	return fmt.Sprintf("position %d of", int(p)+1)
+}
+
+// UserSettings holds per-user preferences. Unset fields fall back to the defaults in
+// DefaultUserSettings
+type UserSettings struct {
+	DuplicatePolicy string          `json:"duplicate_policy,omitempty"`
+	InsertPosition  *InsertPosition `json:"insert_position,omitempty"`
+	// MatchTitleArtist counts songs with the same title and artist as duplicates
+	MatchTitleArtist bool `json:"match_title_artist"`
+	// Playlists holds settings for individual playlists, keyed by playlist ID
+	Playlists map[string]PlaylistSettings `json:"playlists,omitempty"`
+	// Rules pick the playlist add-song uses when none is given. The first matching rule wins
+	Rules []RoutingRule `json:"rules,omitempty"`
+	// DefaultPlaylistID is where add-song adds when no playlist is given and no rule matches
+	DefaultPlaylistID string `json:"default_playlist_id,omitempty"`
+	// SkipAfterRemove skips to the next song once remove-song has removed the current one
+	SkipAfterRemove *bool  `json:"skip_after_remove,omitempty"`
+	Verbosity       string `json:"verbosity,omitempty"`
+	// BriefLanguage is the language of the short confirmations used when Verbosity is brief, e.g.
+	// "en" or "es". Other responses are always in English
+	BriefLanguage string `json:"brief_language,omitempty"`
+	// Language is the former name of BriefLanguage. It is still accepted, and WithDefaults moves
+	// it over
+	Language string `json:"language,omitempty"`
+	// TimeZone is an IANA time zone such as "Europe/Paris", used for the times in rules
+	TimeZone string `json:"time_zone,omitempty"`
+	// SmartPlaylists are regenerated daily from the listening archive and history
+	SmartPlaylists []SmartPlaylist `json:"smart_playlists,omitempty"`
+}
+
+// PlaylistSettings holds preferences for a single playlist
+type PlaylistSettings struct {
+	// MaxTracks keeps the playlist at most this long by rotating out the oldest tracks after
+	// adding. 0 means no limit
+	MaxTracks int `json:"max_tracks,omitempty"`
+	// MaxDurationMinutes does the same for the playlist's total length. 0 means no limit
+	MaxDurationMinutes int `json:"max_duration_minutes,omitempty"`
+	// ArchivePlaylistID receives the tracks that are rotated out, if set
+	ArchivePlaylistID string `json:"archive_playlist_id,omitempty"`
+
+	// Content policies checked before adding to the playlist
+	BlockExplicit   bool `json:"block_explicit,omitempty"`
+	MaxTrackSeconds int  `json:"max_track_seconds,omitempty"`
+	// AllowedArtists, if set, only lets in songs by these artists. Artists are names, IDs or links
+	AllowedArtists []string `json:"allowed_artists,omitempty"`
+	BlockedArtists []string `json:"blocked_artists,omitempty"`
+	BlockEpisodes  bool     `json:"block_episodes,omitempty"`
+}
+
+// HasLimit reports whether tracks are rotated out of the playlist
+func (s PlaylistSettings) HasLimit() bool {
+	return s.MaxTracks > 0 || s.MaxDurationMinutes > 0
+}
+
+// DefaultUserSettings returns the behavior used when a user hasn't changed anything
+func DefaultUserSettings() UserSettings {
+	position := InsertAtBottom
+	skipAfterRemove := true
+	return UserSettings{
+		DuplicatePolicy: DuplicatesSkip,
+		InsertPosition:  &position,
+		SkipAfterRemove: &skipAfterRemove,
+		Verbosity:       VerbosityNormal,
+		BriefLanguage:   "en",
+		TimeZone:        "UTC",
+	}
+}
+
+// WithDefaults fills in unset fields from DefaultUserSettings
+func (s UserSettings) WithDefaults() UserSettings {
+	defaults := DefaultUserSettings()
+	if s.DuplicatePolicy == "" {
+		s.DuplicatePolicy = defaults.DuplicatePolicy
+	}
+	if s.InsertPosition == nil {
+		s.InsertPosition = defaults.InsertPosition
+	}
+	if s.SkipAfterRemove == nil {
+		s.SkipAfterRemove = defaults.SkipAfterRemove
+	}
+	if s.Verbosity == "" {
+		s.Verbosity = defaults.Verbosity
+	}
+	if s.Language != "" {
+		s.BriefLanguage, s.Language = s.Language, ""
+	}
+	if s.BriefLanguage == "" {
+		s.BriefLanguage = defaults.BriefLanguage
+	}
+	if s.TimeZone == "" {
+		s.TimeZone = defaults.TimeZone
+	}
+	return s
+}
+
+// Validate checks that every set field holds a supported value
+func (s UserSettings) Validate() error {
+	err := ValidateDuplicatePolicy(s.DuplicatePolicy)
+	if err != nil {
+		return err
+	}
+	for playlistID, playlist := range s.Playlists {
+		err = validatePlaylistSettings(playlistID, playlist)
+		if err != nil {
+			return err
+		}
+	}
+	for _, rule := range s.Rules {
+		err = rule.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	names := map[string]bool{}
+	for _, playlist := range s.SmartPlaylists {
+		err = playlist.Validate()
+		if err != nil {
+			return err
+		}
+		if names[playlist.Name] {
+			return fmt.Errorf("there are two smart playlists named %q", playlist.Name)
+		}
+		names[playlist.Name] = true
+	}
+	if s.DefaultPlaylistID != "" {
+		if id, err := ParseSpotifyID(s.DefaultPlaylistID, RefTypePlaylist); err != nil || id != s.DefaultPlaylistID {
+			return fmt.Errorf("invalid default_playlist_id %q: use a playlist ID", s.DefaultPlaylistID)
+		}
+	}
+	switch s.Verbosity {
+	case "", VerbosityNormal, VerbosityBrief:
+	default:
+		return fmt.Errorf("invalid verbosity %q: use %q or %q", s.Verbosity, VerbosityNormal, VerbosityBrief)
+	}
+	for _, language := range []string{s.BriefLanguage, s.Language} {
+		if _, supported := briefMessages[language]; language != "" && !supported {
+			return fmt.Errorf("unsupported brief_language %q: use one of %s", language, strings.Join(SupportedLanguages(), ", "))
+		}
+	}
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		return fmt.Errorf("invalid time_zone %q: use an IANA time zone such as \"Europe/Paris\"", s.TimeZone)
+	}
+	return nil
+}
+
+func validatePlaylistSettings(playlistID string, s PlaylistSettings) error {
+	if id, err := ParseSpotifyID(playlistID, RefTypePlaylist); err != nil || id != playlistID {
+		return fmt.Errorf("invalid playlist %q in playlists: use a playlist ID", playlistID)
+	}
+	if s.MaxTracks < 0 || s.MaxDurationMinutes < 0 || s.MaxTrackSeconds < 0 {
+		return fmt.Errorf("invalid limits for playlist %s: max_tracks, max_duration_minutes and max_track_seconds can't be negative", playlistID)
+	}
+	if s.ArchivePlaylistID != "" {
+		if id, err := ParseSpotifyID(s.ArchivePlaylistID, RefTypePlaylist); err != nil || id != s.ArchivePlaylistID {
+			return fmt.Errorf("invalid archive_playlist_id %q for playlist %s: use a playlist ID", s.ArchivePlaylistID, playlistID)
+		}
+		if s.ArchivePlaylistID == playlistID {
+			return fmt.Errorf("playlist %s can't be its own archive", playlistID)
+		}
+	}
+	return nil
+}
+
+// Location is the user's time zone, or UTC if it can't be loaded
+func (s UserSettings) Location() *time.Location {
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// ValidateDuplicatePolicy accepts "" (use the default) or one of the Duplicates* policies
+func ValidateDuplicatePolicy(policy string) error {
+	switch policy {
+	case "", DuplicatesSkip, DuplicatesAllow, DuplicatesMoveToTop:
+		return nil
+	}
+	return fmt.Errorf("invalid duplicate policy %q: use %q, %q or %q", policy, DuplicatesSkip, DuplicatesAllow, DuplicatesMoveToTop)
+}
+
+// Retrieves a user's settings, with defaults applied
+func GetUserSettings(userID string, conn redis.Conn) (UserSettings, error) {
+	defer conn.Close()
+
+	var settings UserSettings
+	data, err := redis.Bytes(conn.Do("GET", fmt.Sprintf("settings:%s", userID)))
+	if err == redis.ErrNil {
+		return settings.WithDefaults(), nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("failed to retrieve user settings: %v", err)
+	}
+
+	err = json.Unmarshal(data, &settings)
+	if err != nil {
+		return settings, fmt.Errorf("failed to unmarshal user settings: %v", err)
+	}
+
+	return settings.WithDefaults(), nil
+}
+
+// Stores a user's settings
+func SetUserSettings(userID string, settings UserSettings, conn redis.Conn) error {
+	defer conn.Close()
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user settings: %v", err)
+	}
+
+	_, err = conn.Do("SET", fmt.Sprintf("settings:%s", userID), data)
+	if err != nil {
+		return fmt.Errorf("failed to store user settings: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertPosition_JSON(t *testing.T) {
+	var position InsertPosition
+
+	require.NoError(t, json.Unmarshal([]byte(`"top"`), &position))
+	assert.Equal(t, InsertAtTop, position)
+	require.NoError(t, json.Unmarshal([]byte(`"bottom"`), &position))
+	assert.Equal(t, InsertAtBottom, position)
+	require.NoError(t, json.Unmarshal([]byte(`5`), &position))
+	assert.Equal(t, InsertPosition(5), position)
+	require.NoError(t, json.Unmarshal([]byte(`"5"`), &position))
+	assert.Equal(t, InsertPosition(5), position)
+
+	assert.Error(t, json.Unmarshal([]byte(`"middle"`), &position))
+	assert.Error(t, json.Unmarshal([]byte(`-3`), &position))
+
+	data, err := json.Marshal(InsertAtBottom)
+	require.NoError(t, err)
+	assert.Equal(t, `"bottom"`, string(data))
+	data, err = json.Marshal(InsertPosition(3))
+	require.NoError(t, err)
+	assert.Equal(t, `3`, string(data))
+}
+
+func TestUserSettings_Defaults(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	settings, err := GetUserSettings("user-1", mock)
+	require.NoError(t, err)
+	assert.Equal(t, DuplicatesSkip, settings.DuplicatePolicy)
+	assert.Equal(t, InsertAtBottom, *settings.InsertPosition)
+	assert.True(t, *settings.SkipAfterRemove)
+	assert.Equal(t, VerbosityNormal, settings.Verbosity)
+	assert.Equal(t, "en", settings.BriefLanguage)
+	assert.Equal(t, time.UTC, settings.Location())
+}
+
+func TestUserSettings_FormerLanguageField(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{"settings:user-1": []byte(`{"verbosity": "brief", "language": "fr"}`)}}
+
+	settings, err := GetUserSettings("user-1", mock)
+	require.NoError(t, err)
+	assert.Equal(t, "fr", settings.BriefLanguage)
+	assert.Empty(t, settings.Language)
+	assert.Equal(t, "Ajoutée", settings.Message(MessageAdded, "Song added to Gym"))
+}
+
+func TestUserSettings_SetGet(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+	top := InsertAtTop
+
+	require.NoError(t, SetUserSettings("user-1", UserSettings{DuplicatePolicy: DuplicatesMoveToTop, InsertPosition: &top}, mock))
+
+	settings, err := GetUserSettings("user-1", mock)
+	require.NoError(t, err)
+	assert.Equal(t, DuplicatesMoveToTop, settings.DuplicatePolicy)
+	assert.Equal(t, InsertAtTop, *settings.InsertPosition)
+}
+
+func TestUserSettings_Validate(t *testing.T) {
+	assert.NoError(t, UserSettings{}.Validate())
+	assert.NoError(t, UserSettings{DuplicatePolicy: DuplicatesAllow}.Validate())
+	assert.Error(t, UserSettings{DuplicatePolicy: "sometimes"}.Validate())
+}
+
+func TestUserSettings_ValidatePlaylists(t *testing.T) {
+	playlistID, archiveID := "37i9dQZF1DXcBWIGoYBM5M", "37i9dQZF1DX0XUsuxWHRQd"
+
+	valid := UserSettings{Playlists: map[string]PlaylistSettings{
+		playlistID: {MaxTracks: 50, ArchivePlaylistID: archiveID},
+	}}
+	assert.NoError(t, valid.Validate())
+
+	for _, playlists := range []map[string]PlaylistSettings{
+		{"https://open.spotify.com/playlist/" + playlistID: {MaxTracks: 50}},
+		{playlistID: {MaxTracks: -1}},
+		{playlistID: {MaxDurationMinutes: 60, ArchivePlaylistID: "archive"}},
+		{playlistID: {MaxTracks: 50, ArchivePlaylistID: playlistID}},
+	} {
+		assert.Error(t, UserSettings{Playlists: playlists}.Validate())
+	}
+}
+
+func TestUserSettings_ValidatePreferences(t *testing.T) {
+	assert.NoError(t, UserSettings{DefaultPlaylistID: "37i9dQZF1DXcBWIGoYBM5M", Verbosity: VerbosityBrief, BriefLanguage: "es", TimeZone: "Europe/Paris"}.Validate())
+	assert.Error(t, UserSettings{DefaultPlaylistID: "gym"}.Validate())
+	assert.Error(t, UserSettings{Verbosity: "chatty"}.Validate())
+	assert.Error(t, UserSettings{Language: "xx"}.Validate())
+	assert.Error(t, UserSettings{BriefLanguage: "xx"}.Validate())
+	assert.Error(t, UserSettings{TimeZone: "Mars/Olympus_Mons"}.Validate())
+}
+
+func TestUserSettings_Message(t *testing.T) {
+	full := "Song added to Gym"
+
+	assert.Equal(t, full, DefaultUserSettings().Message(MessageAdded, full))
+	assert.Equal(t, "Added", UserSettings{Verbosity: VerbosityBrief, BriefLanguage: "en"}.Message(MessageAdded, full))
+	assert.Equal(t, "Añadida", UserSettings{Verbosity: VerbosityBrief, BriefLanguage: "es"}.Message(MessageAdded, full))
+	assert.Equal(t, "Undone", UserSettings{Verbosity: VerbosityBrief, BriefLanguage: "xx"}.Message(MessageUndone, full))
+}
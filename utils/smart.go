@@ -0,0 +1,267 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Kinds of smart playlist
+const (
+	// SmartMostPlayed holds the tracks played at least MinPlays times in the period
+	SmartMostPlayed = "most_played"
+	// SmartFirstHeard holds the tracks first heard in the period
+	SmartFirstHeard = "first_heard"
+	// SmartAdded holds the tracks add-song added in the period
+	SmartAdded = "added"
+)
+
+// Calendar periods a smart playlist can cover, in the user's time zone
+const (
+	PeriodToday     = "today"
+	PeriodThisWeek  = "this_week"
+	PeriodThisMonth = "this_month"
+)
+
+// Defaults for smart playlist fields left unset
+const (
+	defaultSmartDays     = 30
+	defaultSmartMinPlays = 5
+	defaultSmartLimit    = 100
+)
+
+// Maximum number of tracks in a smart playlist
+const maxSmartLimit = 1000
+
+// SmartPlaylist defines a playlist that is regenerated daily from the listening archive or the
+// history, e.g. "played 5+ times in the last 30 days"
+type SmartPlaylist struct {
+	// Name is the Spotify playlist's name, and identifies the smart playlist across runs
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Period is a calendar period such as "this_month". Without one, the last Days days are used
+	Period string `json:"period,omitempty"`
+	Days   int    `json:"days,omitempty"`
+	// MinPlays is the number of plays a most_played track needs
+	MinPlays int `json:"min_plays,omitempty"`
+	// Limit is the maximum number of tracks
+	Limit int `json:"limit,omitempty"`
+}
+
+// Validate checks that the smart playlist can be generated
+func (p SmartPlaylist) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("smart playlists need a name")
+	}
+	switch p.Type {
+	case SmartMostPlayed, SmartFirstHeard, SmartAdded:
+	default:
+		return fmt.Errorf("invalid type %q for smart playlist %q: use %q, %q or %q", p.Type, p.Name, SmartMostPlayed, SmartFirstHeard, SmartAdded)
+	}
+	switch p.Period {
+	case "", PeriodToday, PeriodThisWeek, PeriodThisMonth:
+	default:
+		return fmt.Errorf("invalid period %q for smart playlist %q: use %q, %q or %q", p.Period, p.Name, PeriodToday, PeriodThisWeek, PeriodThisMonth)
+	}
+	if p.Period != "" && p.Days != 0 {
+		return fmt.Errorf("smart playlist %q can't have both a period and days", p.Name)
+	}
+	if p.Days < 0 || p.MinPlays < 0 {
+		return fmt.Errorf("invalid smart playlist %q: days and min_plays can't be negative", p.Name)
+	}
+	if p.Limit < 0 || p.Limit > maxSmartLimit {
+		return fmt.Errorf("invalid limit for smart playlist %q: use a number from 1 to %d", p.Name, maxSmartLimit)
+	}
+	return nil
+}
+
+// Start is when the smart playlist's period begins, as of now in location
+func (p SmartPlaylist) Start(now time.Time, location *time.Location) time.Time {
+	now = now.In(location)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	switch p.Period {
+	case PeriodToday:
+		return today
+	case PeriodThisWeek:
+		// Weeks start on Monday
+		return today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	case PeriodThisMonth:
+		return today.AddDate(0, 0, 1-today.Day())
+	}
+	days := p.Days
+	if days == 0 {
+		days = defaultSmartDays
+	}
+	return now.AddDate(0, 0, -days)
+}
+
+// Describes the smart playlist, e.g. "Played 5+ times in the last 30 days"
+func (p SmartPlaylist) Describe() string {
+	period := fmt.Sprintf("in the last %d days", p.Days)
+	switch {
+	case p.Period == PeriodToday:
+		period = "today"
+	case p.Period == PeriodThisWeek:
+		period = "this week"
+	case p.Period == PeriodThisMonth:
+		period = "this month"
+	case p.Days == 0:
+		period = fmt.Sprintf("in the last %d days", defaultSmartDays)
+	case p.Days == 1:
+		period = "in the last day"
+	}
+
+	switch p.Type {
+	case SmartMostPlayed:
+		return fmt.Sprintf("Played %d+ times %s", p.minPlays(), period)
+	case SmartFirstHeard:
+		return fmt.Sprintf("First heard %s", period)
+	}
+	return fmt.Sprintf("Added with Siri %s", period)
+}
+
+func (p SmartPlaylist) minPlays() int {
+	if p.MinPlays == 0 {
+		return defaultSmartMinPlays
+	}
+	return p.MinPlays
+}
+
+func (p SmartPlaylist) limit() int {
+	if p.Limit == 0 {
+		return defaultSmartLimit
+	}
+	return p.Limit
+}
+
+// NeedsHistory reports whether the smart playlist is generated from the history rather than
+// the listening archive
+func (p SmartPlaylist) NeedsHistory() bool {
+	return p.Type == SmartAdded
+}
+
+// SmartPlaylistTracks lists the track URIs of a smart playlist in the order they belong in.
+// plays is the listening archive and events the history, both newest first
+func SmartPlaylistTracks(p SmartPlaylist, plays []Play, events []HistoryEvent, now time.Time, location *time.Location) []string {
+	start := p.Start(now, location)
+
+	var uris []string
+	switch p.Type {
+	case SmartMostPlayed:
+		// Most played first, ties broken by the most recently played
+		counts := map[string]int{}
+		for _, play := range plays {
+			if play.PlayedAt.Before(start) || play.PlayedAt.After(now) {
+				continue
+			}
+			if counts[play.Track] == 0 {
+				uris = append(uris, play.Track)
+			}
+			counts[play.Track]++
+		}
+		sort.SliceStable(uris, func(i, j int) bool {
+			return counts[uris[i]] > counts[uris[j]]
+		})
+		for i, uri := range uris {
+			if counts[uri] < p.minPlays() {
+				uris = uris[:i]
+				break
+			}
+		}
+
+	case SmartFirstHeard:
+		// Plays are newest first, so a track's first play is the last one seen
+		firstHeard := map[string]time.Time{}
+		for _, play := range plays {
+			firstHeard[play.Track] = play.PlayedAt
+		}
+		seen := map[string]bool{}
+		for _, play := range plays {
+			first := firstHeard[play.Track]
+			if seen[play.Track] || first.Before(start) || first.After(now) {
+				continue
+			}
+			seen[play.Track] = true
+			uris = append(uris, play.Track)
+		}
+		sort.SliceStable(uris, func(i, j int) bool {
+			return firstHeard[uris[i]].After(firstHeard[uris[j]])
+		})
+
+	case SmartAdded:
+		seen := map[string]bool{}
+		for _, event := range events {
+			if event.Type != HistoryAdd || event.Result != "added" || event.Track == "" {
+				continue
+			}
+			if seen[event.Track] || event.Time.Before(start) || event.Time.After(now) {
+				continue
+			}
+			seen[event.Track] = true
+			uris = append(uris, event.Track)
+		}
+	}
+
+	if len(uris) > p.limit() {
+		uris = uris[:p.limit()]
+	}
+	return uris
+}
+
+// SyncPlaylistTracks makes a playlist hold exactly uris, in order. A playlist that already does
+// is left alone, so regenerating an unchanged smart playlist doesn't touch it. Reports whether
+// the playlist was rewritten
+func SyncPlaylistTracks(accessToken, playlistID string, uris []string) (bool, error) {
+	items, err := GetPlaylistItems(accessToken, playlistID)
+	if err != nil {
+		return false, err
+	}
+	if sameTracks(items, uris) {
+		return false, nil
+	}
+
+	end := min(maxTracksPerAdd, len(uris))
+	_, err = ReplacePlaylistTracks(accessToken, playlistID, uris[:end])
+	if err != nil {
+		return false, err
+	}
+	for start := end; start < len(uris); start += maxTracksPerAdd {
+		end = min(start+maxTracksPerAdd, len(uris))
+		_, err = AddTracksToPlaylist(accessToken, playlistID, uris[start:end], -1)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+func sameTracks(items []PlaylistItem, uris []string) bool {
+	if len(items) != len(uris) {
+		return false
+	}
+	for i, item := range items {
+		// Spotify may relink a track for the user's market, so compare the original too
+		if item.Track == nil || (item.Track.URI != uris[i] && "spotify:track:"+item.Track.OriginalID() != uris[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Retrieves the Spotify playlists generated for a user's smart playlists, keyed by name
+func GetSmartPlaylistIDs(userID string, conn redis.Conn) (map[string]string, error) {
+	defer conn.Close()
+
+	return getStringMap(fmt.Sprintf("smart-playlists:%s", userID), "smart playlists", conn)
+}
+
+// Stores the Spotify playlist generated for one of a user's smart playlists
+func SetSmartPlaylistID(userID, name, playlistID string, conn redis.Conn) error {
+	defer conn.Close()
+
+	return setStringMapField(fmt.Sprintf("smart-playlists:%s", userID), name, playlistID, "smart playlist", conn)
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmartPlaylistTracks_MostPlayed(t *testing.T) {
+	now := time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC)
+	// Newest first, as the archive returns them
+	plays := []Play{
+		testPlay("Clocks", "Coldplay", now.Add(-time.Hour)),
+		testPlay("Yellow", "Coldplay", now.Add(-2*time.Hour)),
+		testPlay("Clocks", "Coldplay", now.Add(-3*time.Hour)),
+		testPlay("Yellow", "Coldplay", now.Add(-4*time.Hour)),
+		testPlay("Yellow", "Coldplay", now.Add(-5*time.Hour)),
+		testPlay("Hysteria", "Muse", now.Add(-6*time.Hour)),
+		// Outside the last 7 days
+		testPlay("Hysteria", "Muse", now.AddDate(0, 0, -8)),
+		testPlay("Hysteria", "Muse", now.AddDate(0, 0, -9)),
+	}
+
+	smart := SmartPlaylist{Name: "On repeat", Type: SmartMostPlayed, Days: 7, MinPlays: 2}
+	uris := SmartPlaylistTracks(smart, plays, nil, now, time.UTC)
+	assert.Equal(t, []string{"spotify:track:Yellow", "spotify:track:Clocks"}, uris)
+
+	smart.Limit = 1
+	assert.Equal(t, []string{"spotify:track:Yellow"}, SmartPlaylistTracks(smart, plays, nil, now, time.UTC))
+}
+
+func TestSmartPlaylistTracks_FirstHeardThisMonth(t *testing.T) {
+	now := time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC)
+	plays := []Play{
+		testPlay("Yellow", "Coldplay", now.AddDate(0, 0, -1)),
+		testPlay("Hysteria", "Muse", now.AddDate(0, 0, -2)),
+		testPlay("Clocks", "Coldplay", now.AddDate(0, 0, -3)),
+		testPlay("Hysteria", "Muse", now.AddDate(0, 0, -10)),
+		// Clocks was first heard last month
+		testPlay("Clocks", "Coldplay", now.AddDate(0, -1, 0)),
+	}
+
+	smart := SmartPlaylist{Name: "New to me", Type: SmartFirstHeard, Period: PeriodThisMonth}
+	uris := SmartPlaylistTracks(smart, plays, nil, now, time.UTC)
+	assert.Equal(t, []string{"spotify:track:Yellow", "spotify:track:Hysteria"}, uris)
+	assert.Equal(t, "First heard this month", smart.Describe())
+}
+
+func TestSmartPlaylistTracks_AddedThisWeek(t *testing.T) {
+	// A Wednesday, so the week began on Monday the 16th
+	now := time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC)
+	events := []HistoryEvent{
+		{Time: now.Add(-time.Hour), Type: HistoryAdd, Track: "spotify:track:a", Result: "added"},
+		{Time: now.Add(-2 * time.Hour), Type: HistoryAdd, Track: "spotify:track:b", Result: "duplicate"},
+		{Time: now.Add(-3 * time.Hour), Type: HistoryRemove, Track: "spotify:track:c", Result: "removed"},
+		{Time: now.AddDate(0, 0, -1), Type: HistoryAdd, Track: "spotify:track:a", Result: "added"},
+		{Time: now.AddDate(0, 0, -2), Type: HistoryAdd, Track: "spotify:track:d", Result: "added"},
+		{Time: now.AddDate(0, 0, -3), Type: HistoryAdd, Track: "spotify:track:e", Result: "added"},
+	}
+
+	smart := SmartPlaylist{Name: "Siri finds", Type: SmartAdded, Period: PeriodThisWeek}
+	assert.Equal(t, time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC), smart.Start(now, time.UTC))
+	uris := SmartPlaylistTracks(smart, nil, events, now, time.UTC)
+	assert.Equal(t, []string{"spotify:track:a", "spotify:track:d"}, uris)
+}
+
+func TestSmartPlaylist_Validate(t *testing.T) {
+	assert.NoError(t, SmartPlaylist{Name: "On repeat", Type: SmartMostPlayed}.Validate())
+	assert.Error(t, SmartPlaylist{Type: SmartMostPlayed}.Validate())
+	assert.Error(t, SmartPlaylist{Name: "On repeat", Type: "liked"}.Validate())
+	assert.Error(t, SmartPlaylist{Name: "On repeat", Type: SmartMostPlayed, Period: PeriodThisWeek, Days: 7}.Validate())
+	assert.Error(t, SmartPlaylist{Name: "On repeat", Type: SmartMostPlayed, Limit: 5000}.Validate())
+
+	settings := UserSettings{SmartPlaylists: []SmartPlaylist{
+		{Name: "On repeat", Type: SmartMostPlayed},
+		{Name: "On repeat", Type: SmartFirstHeard},
+	}}
+	assert.Error(t, settings.Validate())
+}
+
+func TestSmartPlaylistIDs(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	require.NoError(t, SetSmartPlaylistID("user-1", "On repeat", "playlist-1", mock))
+	playlistIDs, err := GetSmartPlaylistIDs("user-1", mock)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"On repeat": "playlist-1"}, playlistIDs)
+}
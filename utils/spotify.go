@@ -2,10 +2,13 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 )
 
 // Spotify API Endpoints
@@ -15,29 +18,157 @@ const (
 	SpotifyTokenURL   = "https://accounts.spotify.com/api/token"
 )
 
-func GetCurrentlyPlayingSong(accessToken string) (string, string, string, string, string, error) {
-	req, err := http.NewRequest("GET", SpotifyAPIBaseURL+"/me/player/currently-playing", nil)
+// Playlist represents the playlist fields we use from Spotify's playlist objects
+type Playlist struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	SnapshotID    string `json:"snapshot_id"`
+	Collaborative bool   `json:"collaborative"`
+	Owner         struct {
+		ID string `json:"id"`
+	} `json:"owner"`
+	Tracks struct {
+		Total int `json:"total"`
+	} `json:"tracks"`
+}
+
+// ErrPlaylistNotFound is returned when a playlist doesn't exist or isn't visible to the user
+var ErrPlaylistNotFound = errors.New("playlist not found")
+
+// PlaylistPermission describes what the user may do with a playlist
+type PlaylistPermission string
+
+const (
+	PlaylistOwned         PlaylistPermission = "owned"
+	PlaylistCollaborative PlaylistPermission = "collaborative"
+	PlaylistReadOnly      PlaylistPermission = "read_only"
+	PlaylistNotFound      PlaylistPermission = "not_found"
+)
+
+// CanEdit reports whether the user may add and remove tracks
+func (p PlaylistPermission) CanEdit() bool {
+	return p == PlaylistOwned || p == PlaylistCollaborative
+}
+
+// PlaylistItem is a track at a position in a playlist
+type PlaylistItem struct {
+	AddedAt time.Time `json:"added_at"`
+	Track   *Track    `json:"track"`
+}
+
+// Track represents the track fields we use from Spotify's track objects
+type Track struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	URI     string `json:"uri"`
+	Artists []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"artists"`
+	// DurationMs is the track length in milliseconds
+	DurationMs int  `json:"duration_ms"`
+	Explicit   bool `json:"explicit"`
+	// LinkedFrom is the originally requested track when Spotify relinked it for the user's market
+	LinkedFrom *struct {
+		ID string `json:"id"`
+	} `json:"linked_from,omitempty"`
+	ExternalIDs struct {
+		ISRC string `json:"isrc"`
+	} `json:"external_ids"`
+	// Show is set for podcast episodes, which have no artists
+	Show *struct {
+		Name string `json:"name"`
+	} `json:"show,omitempty"`
+}
+
+// IsEpisode reports whether this is a podcast episode rather than a song
+func (t Track) IsEpisode() bool {
+	return strings.HasPrefix(t.URI, "spotify:episode:")
+}
+
+// OriginalID is the ID the track was saved under, before any relinking. Playlist edits must
+// refer to tracks by this ID
+func (t Track) OriginalID() string {
+	if t.LinkedFrom != nil && t.LinkedFrom.ID != "" {
+		return t.LinkedFrom.ID
+	}
+	return t.ID
+}
+
+// SavedURI is the URI the item was saved under, before any relinking. Podcast episodes and
+// local files keep their own URIs. Playlist edits must refer to items by this URI
+func (t Track) SavedURI() string {
+	if t.URI != "" && !strings.HasPrefix(t.URI, "spotify:track:") {
+		return t.URI
+	}
+	return "spotify:track:" + t.OriginalID()
+}
+
+// CurrentlyPlaying describes the track that is playing and where it is playing from
+type CurrentlyPlaying struct {
+	Track Track
+	// ContextType is "playlist", "collection" (Liked Songs), "album", "artist", ... or "" when
+	// there is no context, e.g. when playing from the queue
+	ContextType  string
+	ContextURI   string
+	PlaylistID   string
+	PlaylistName string
+	// Device is the device playback is on
+	Device Device
+}
+
+// Device represents the device fields we use from Spotify's device objects
+type Device struct {
+	Name string `json:"name"`
+	// Type is "Computer", "Smartphone", "Speaker", ...
+	Type string `json:"type"`
+}
+
+// Context type for Liked Songs, reported with a spotify:user:<id>:collection URI
+const ContextTypeCollection = "collection"
+
+// IsLikedSongs reports whether the track is playing from the user's Liked Songs
+func (c *CurrentlyPlaying) IsLikedSongs() bool {
+	return c.ContextType == ContextTypeCollection || strings.HasSuffix(c.ContextURI, ":collection")
+}
+
+// Fetches the currently playing track and its context. Returns nil if nothing is playing
+func GetCurrentlyPlaying(accessToken string) (*CurrentlyPlaying, error) {
+	return getCurrentlyPlaying(accessToken, false)
+}
+
+// GetCurrentlyPlayingItem is GetCurrentlyPlaying, except that a podcast episode that is playing
+// is returned too, as a Track whose IsEpisode is true
+func GetCurrentlyPlayingItem(accessToken string) (*CurrentlyPlaying, error) {
+	return getCurrentlyPlaying(accessToken, true)
+}
+
+func getCurrentlyPlaying(accessToken string, includeEpisodes bool) (*CurrentlyPlaying, error) {
+	url := SpotifyAPIBaseURL + "/me/player?market=from_token"
+	if includeEpisodes {
+		url += "&additional_types=episode"
+	}
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", "", "", "", "", err
+		return nil, err
 	}
 	req.Header.Add("Authorization", "Bearer "+accessToken)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := spotifyClient.Do(req)
 	if err != nil {
-		return "", "", "", "", "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	// Handle HTTP 204 - No Content (no song playing)
 	if resp.StatusCode == 204 {
-		return "", "", "", "", "", nil // No error, just no song playing
+		return nil, nil // No error, just no song playing
 	}
 
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", "", "", "", "", err
+		return nil, err
 	}
 
 	// Handle non-200 errors
@@ -51,59 +182,61 @@ func GetCurrentlyPlayingSong(accessToken string) (string, string, string, string
 
 		// Try to parse Spotify's error message
 		if json.Unmarshal(body, &errData) == nil {
-			return "", "", "", "", "", fmt.Errorf("spotify API error: %s", errData.Error.Message)
+			return nil, fmt.Errorf("spotify API error: %s", errData.Error.Message)
 		}
 
 		// If parsing fails, return raw response
-		return "", "", "", "", "", fmt.Errorf("spotify API request failed: %s", body)
+		return nil, fmt.Errorf("spotify API request failed: %s", spotifyErrorMessage(body))
 	}
 
 	// Parse JSON response
-	var data map[string]interface{}
+	var data struct {
+		Item    *Track `json:"item"`
+		Context *struct {
+			Type string `json:"type"`
+			URI  string `json:"uri"`
+		} `json:"context"`
+		Device Device `json:"device"`
+	}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return "", "", "", "", "", err
+		return nil, err
 	}
 
-	var songID, songName, artistName, playlistID, playlistName string
-
-	if item, exists := data["item"].(map[string]interface{}); exists {
-		if id, exists := item["id"].(string); exists {
-			songID = id
-		}
-		if name, exists := item["name"].(string); exists {
-			songName = name
-		}
-
-		if artists, exists := item["artists"].([]interface{}); exists && len(artists) > 0 {
-			if firstArtist, ok := artists[0].(map[string]interface{}); ok {
-				if artistNameValue, exists := firstArtist["name"].(string); exists {
-					artistName = artistNameValue
-				}
-			}
+	if data.Item != nil && data.Item.IsEpisode() && includeEpisodes {
+		if data.Item.ID == "" || data.Item.Name == "" {
+			return nil, fmt.Errorf("could not find the episode ID or name")
 		}
+	} else if data.Item == nil || data.Item.ID == "" || data.Item.Name == "" || len(data.Item.Artists) == 0 || data.Item.Artists[0].Name == "" {
+		return nil, fmt.Errorf("could not find the song ID, name, or artist")
 	}
 
-	if context, exists := data["context"].(map[string]interface{}); exists {
-		if uri, exists := context["uri"].(string); exists {
-			if strings.HasPrefix(uri, "spotify:playlist:") {
-				playlistID = strings.TrimPrefix(uri, "spotify:playlist:")
-			}
+	playing := &CurrentlyPlaying{Track: *data.Item, Device: data.Device}
+	if data.Context != nil {
+		playing.ContextType = data.Context.Type
+		playing.ContextURI = data.Context.URI
+		if ref, err := ParseSpotifyRef(data.Context.URI, ""); err == nil && ref.Type == RefTypePlaylist {
+			playing.PlaylistID = ref.ID
 		}
 	}
 
-	if playlistID != "" {
-		playlistName, err = GetPlaylistName(accessToken, playlistID)
+	if playing.PlaylistID != "" {
+		playing.PlaylistName, err = GetPlaylistName(accessToken, playing.PlaylistID)
 		if err != nil {
-			playlistName = "unknown"
+			playing.PlaylistName = "unknown"
 		}
 	}
 
-	if songID == "" || songName == "" || artistName == "" {
-		return "", "", "", "", "", fmt.Errorf("could not find the song ID, name, or artist")
+	return playing, nil
+}
+
+func GetCurrentlyPlayingSong(accessToken string) (string, string, string, string, string, error) {
+	playing, err := GetCurrentlyPlaying(accessToken)
+	if err != nil || playing == nil {
+		return "", "", "", "", "", err
 	}
 
-	return songID, songName, artistName, playlistID, playlistName, nil
+	return playing.Track.ID, playing.Track.Name, playing.Track.Artists[0].Name, playing.PlaylistID, playing.PlaylistName, nil
 }
 
 func GetPlaylistName(accessToken, playlistID string) (string, error) {
@@ -115,8 +248,7 @@ func GetPlaylistName(accessToken, playlistID string) (string, error) {
 	}
 	req.Header.Add("Authorization", "Bearer "+accessToken)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := spotifyClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -124,7 +256,7 @@ func GetPlaylistName(accessToken, playlistID string) (string, error) {
 
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to retrieve playlist name: %s", body)
+		return "", fmt.Errorf("failed to retrieve playlist name: %s", spotifyErrorMessage(body))
 	}
 
 	var data struct {
@@ -140,75 +272,150 @@ func GetPlaylistName(accessToken, playlistID string) (string, error) {
 }
 
 func AddSongToPlaylist(accessToken, playlistID, songID string) error {
+	_, err := AddTracksToPlaylist(accessToken, playlistID, []string{fmt.Sprintf("spotify:track:%s", songID)}, -1)
+	return err
+}
+
+// Adds up to 100 track URIs to a playlist in a single request, inserting them at position, or
+// appending them when position is negative. Returns the playlist's new snapshot ID
+func AddTracksToPlaylist(accessToken, playlistID string, uris []string, position int) (string, error) {
 	url := fmt.Sprintf("%s/playlists/%s/tracks", SpotifyAPIBaseURL, playlistID)
 
 	body := map[string]interface{}{
-		"uris": []string{fmt.Sprintf("spotify:track:%s", songID)},
+		"uris": uris,
+	}
+	if position >= 0 {
+		body["position"] = position
 	}
 
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	req, err := http.NewRequest("POST", url, strings.NewReader(string(jsonBody)))
 	if err != nil {
-		return err
+		return "", err
 	}
 	req.Header.Add("Authorization", "Bearer "+accessToken)
 	req.Header.Add("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := spotifyClient.Do(req)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 201 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to add song to playlist: %s", body)
+		return "", fmt.Errorf("failed to add song to playlist: %s", spotifyErrorMessage(body))
 	}
 
-	return nil
+	var data struct {
+		SnapshotID string `json:"snapshot_id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return "", err
+	}
+
+	return data.SnapshotID, nil
+}
+
+// Replaces every track in a playlist with uris, at most 100 of them. Returns the new snapshot ID
+func ReplacePlaylistTracks(accessToken, playlistID string, uris []string) (string, error) {
+	url := fmt.Sprintf("%s/playlists/%s/tracks", SpotifyAPIBaseURL, playlistID)
+
+	jsonBody, err := json.Marshal(map[string]interface{}{"uris": uris})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("PUT", url, strings.NewReader(string(jsonBody)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := spotifyClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to replace playlist tracks: %s", spotifyErrorMessage(body))
+	}
+
+	var data struct {
+		SnapshotID string `json:"snapshot_id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return "", err
+	}
+
+	return data.SnapshotID, nil
 }
 
 func RemoveSongFromPlaylist(accessToken, playlistID, songID string) error {
+	_, err := RemoveTracksFromPlaylist(accessToken, playlistID, []PlaylistTrackRef{{URI: fmt.Sprintf("spotify:track:%s", songID)}}, "")
+	return err
+}
+
+// PlaylistTrackRef identifies a track to remove from a playlist. Without positions every
+// occurrence of the track is removed
+type PlaylistTrackRef struct {
+	URI       string `json:"uri"`
+	Positions []int  `json:"positions,omitempty"`
+}
+
+// Removes tracks from a playlist. When snapshotID is set, positions refer to that version of the
+// playlist so concurrent edits cannot shift them. Returns the playlist's new snapshot ID
+func RemoveTracksFromPlaylist(accessToken, playlistID string, tracks []PlaylistTrackRef, snapshotID string) (string, error) {
 	url := fmt.Sprintf("%s/playlists/%s/tracks", SpotifyAPIBaseURL, playlistID)
 
 	body := map[string]interface{}{
-		"tracks": []map[string]string{
-			{
-				"uri": fmt.Sprintf("spotify:track:%s", songID),
-			},
-		},
+		"tracks": tracks,
+	}
+	if snapshotID != "" {
+		body["snapshot_id"] = snapshotID
 	}
 
 	jsonBody, err := json.Marshal(body)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	req, err := http.NewRequest("DELETE", url, strings.NewReader(string(jsonBody)))
 	if err != nil {
-		return err
+		return "", err
 	}
 	req.Header.Add("Authorization", "Bearer "+accessToken)
 	req.Header.Add("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := spotifyClient.Do(req)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to remove song from playlist: %s", body)
+		return "", fmt.Errorf("failed to remove song from playlist: %s", spotifyErrorMessage(body))
 	}
 
-	return nil
+	var data struct {
+		SnapshotID string `json:"snapshot_id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return "", err
+	}
+
+	return data.SnapshotID, nil
 }
 
 func SkipSong(accessToken string) error {
@@ -221,8 +428,7 @@ func SkipSong(accessToken string) error {
 	req.Header.Add("Authorization", "Bearer "+accessToken)
 	req.Header.Add("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := spotifyClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -230,55 +436,292 @@ func SkipSong(accessToken string) error {
 
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("Failed to skip song: %s", body)
+		return fmt.Errorf("Failed to skip song: %s", spotifyErrorMessage(body))
 	}
 
 	return nil
 }
 
-func IsPlaylistOwnedByUser(accessToken, playlistID string) (bool, error) {
-	url := fmt.Sprintf("%s/playlists/%s", SpotifyAPIBaseURL, playlistID)
+// Checks whether the user may edit a playlist, using the stored Spotify user ID rather than
+// fetching it again. Also returns the playlist so callers can reuse its snapshot ID
+func GetPlaylistPermission(accessToken, playlistID, userID string) (PlaylistPermission, *Playlist, error) {
+	playlist, err := GetPlaylist(accessToken, playlistID)
+	if err == ErrPlaylistNotFound {
+		return PlaylistNotFound, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	return PlaylistPermissionFor(playlist, userID), playlist, nil
+}
+
+// Owners can always edit their playlists, and Spotify lets anyone edit a collaborative one
+func PlaylistPermissionFor(playlist *Playlist, userID string) PlaylistPermission {
+	if playlist.Owner.ID == userID {
+		return PlaylistOwned
+	}
+	if playlist.Collaborative {
+		return PlaylistCollaborative
+	}
+	return PlaylistReadOnly
+}
+
+// Fetches every playlist the user owns or follows, following pagination
+func GetUserPlaylists(accessToken string) ([]Playlist, error) {
+	url := fmt.Sprintf("%s/me/playlists?limit=50", SpotifyAPIBaseURL)
+	var playlists []Playlist
+
+	for url != "" {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+
+		resp, err := spotifyClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != 200 {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to retrieve playlists: %s", spotifyErrorMessage(body))
+		}
+
+		var data struct {
+			Items []Playlist `json:"items"`
+			Next  string     `json:"next"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&data)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		playlists = append(playlists, data.Items...)
+		url = data.Next
+	}
+
+	return playlists, nil
+}
+
+// Fetches up to 50 tracks by ID. Unknown IDs are omitted from the result
+func GetTracks(accessToken string, trackIDs []string) ([]Track, error) {
+	url := fmt.Sprintf("%s/tracks?market=from_token&ids=%s", SpotifyAPIBaseURL, strings.Join(trackIDs, ","))
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	req.Header.Add("Authorization", "Bearer "+accessToken)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := spotifyClient.Do(req)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
-		return false, fmt.Errorf("failed to retrieve playlist details: %s", body)
+		return nil, fmt.Errorf("failed to retrieve tracks: %s", spotifyErrorMessage(body))
 	}
 
 	var data struct {
-		Owner struct {
-			ID string `json:"id"`
-		} `json:"owner"`
+		Tracks []*Track `json:"tracks"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := []Track{}
+	for _, track := range data.Tracks {
+		if track != nil {
+			tracks = append(tracks, *track)
+		}
 	}
 
+	return tracks, nil
+}
+
+// Fetches podcast episodes by ID. Episodes that don't exist are left out
+func GetEpisodes(accessToken string, episodeIDs []string) ([]Track, error) {
+	url := fmt.Sprintf("%s/episodes?market=from_token&ids=%s", SpotifyAPIBaseURL, strings.Join(episodeIDs, ","))
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	resp, err := spotifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to retrieve episodes: %s", spotifyErrorMessage(body))
+	}
+
+	var data struct {
+		Episodes []*Track `json:"episodes"`
+	}
 	err = json.NewDecoder(resp.Body).Decode(&data)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	episodes := []Track{}
+	for _, episode := range data.Episodes {
+		if episode != nil {
+			episodes = append(episodes, *episode)
+		}
 	}
 
-	// Fetch the user's ID
-	userID, err := GetSpotifyUserID(accessToken)
+	return episodes, nil
+}
+
+// RecentlyPlayedItem is a single play from the user's recently played tracks
+type RecentlyPlayedItem struct {
+	Track    Track     `json:"track"`
+	PlayedAt time.Time `json:"played_at"`
+	Context  *struct {
+		URI string `json:"uri"`
+	} `json:"context"`
+}
+
+// Maximum number of pages of recently played tracks fetched at once
+const maxRecentlyPlayedPages = 10
+
+// Fetches the tracks the user played after the given time, oldest first. Spotify only keeps the
+// last 50 plays
+func GetRecentlyPlayed(accessToken string, after time.Time) ([]RecentlyPlayedItem, error) {
+	url := fmt.Sprintf("%s/me/player/recently-played?limit=50&after=%d", SpotifyAPIBaseURL, after.UnixMilli())
+	var items []RecentlyPlayedItem
+
+	for page := 0; url != "" && page < maxRecentlyPlayedPages; page++ {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+
+		resp, err := spotifyClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != 200 {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to retrieve recently played tracks: %s", spotifyErrorMessage(body))
+		}
+
+		var data struct {
+			Items []RecentlyPlayedItem `json:"items"`
+			Next  string               `json:"next"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&data)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, data.Items...)
+		url = ""
+		if len(data.Items) > 0 {
+			url = data.Next
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].PlayedAt.Before(items[j].PlayedAt)
+	})
+	return items, nil
+}
+
+// Fetches the genres of the given artists, at most 50 at a time
+func GetArtistGenres(accessToken string, artistIDs []string) ([]string, error) {
+	url := fmt.Sprintf("%s/artists?ids=%s", SpotifyAPIBaseURL, strings.Join(artistIDs, ","))
+
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	resp, err := spotifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	return data.Owner.ID == userID, nil
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to retrieve artists: %s", spotifyErrorMessage(body))
+	}
+
+	var data struct {
+		Artists []*struct {
+			Genres []string `json:"genres"`
+		} `json:"artists"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	genres := []string{}
+	for _, artist := range data.Artists {
+		if artist != nil {
+			genres = append(genres, artist.Genres...)
+		}
+	}
+
+	return genres, nil
 }
 
-func IsSongInPlaylist(accessToken, playlistID, songID string) (bool, error) {
-	url := fmt.Sprintf("%s/playlists/%s/tracks", SpotifyAPIBaseURL, playlistID)
+// Fetches a playlist's details, including its current snapshot ID and track count
+func GetPlaylist(accessToken, playlistID string) (*Playlist, error) {
+	url := fmt.Sprintf("%s/playlists/%s?fields=id,name,snapshot_id,collaborative,owner(id),tracks(total)", SpotifyAPIBaseURL, playlistID)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	resp, err := spotifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, ErrPlaylistNotFound
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to retrieve playlist details: %s", spotifyErrorMessage(body))
+	}
+
+	var playlist Playlist
+	err = json.NewDecoder(resp.Body).Decode(&playlist)
+	if err != nil {
+		return nil, err
+	}
+
+	return &playlist, nil
+}
+
+// Reports whether the user follows a playlist. Deleting a playlist only unfollows it, so Spotify
+// keeps serving a playlist its owner deleted and this is how to tell
+func IsFollowingPlaylist(accessToken, playlistID, userID string) (bool, error) {
+	url := fmt.Sprintf("%s/playlists/%s/followers/contains?ids=%s", SpotifyAPIBaseURL, playlistID, userID)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -286,36 +729,287 @@ func IsSongInPlaylist(accessToken, playlistID, songID string) (bool, error) {
 	}
 	req.Header.Add("Authorization", "Bearer "+accessToken)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := spotifyClient.Do(req)
 	if err != nil {
 		return false, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == 404 {
+		return false, ErrPlaylistNotFound
+	}
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
-		return false, fmt.Errorf("failed to retrieve playlist tracks: %s", body)
+		return false, fmt.Errorf("failed to check playlist followers: %s", spotifyErrorMessage(body))
 	}
 
-	var data struct {
-		Items []struct {
-			Track struct {
-				ID string `json:"id"`
-			} `json:"track"`
-		} `json:"items"`
+	var following []bool
+	err = json.NewDecoder(resp.Body).Decode(&following)
+	if err != nil {
+		return false, err
+	}
+
+	return len(following) > 0 && following[0], nil
+}
+
+// Fetches every item in a playlist, following pagination, podcast episodes included. An item's
+// index is its position
+func GetPlaylistItems(accessToken, playlistID string) ([]PlaylistItem, error) {
+	url := fmt.Sprintf("%s/playlists/%s/tracks?limit=100&market=from_token&additional_types=episode", SpotifyAPIBaseURL, playlistID)
+	var items []PlaylistItem
+
+	for url != "" {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+
+		resp, err := spotifyClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != 200 {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to retrieve playlist tracks: %s", spotifyErrorMessage(body))
+		}
+
+		var data struct {
+			Items []PlaylistItem `json:"items"`
+			Next  string         `json:"next"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&data)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, data.Items...)
+		url = data.Next
+	}
+
+	return items, nil
+}
+
+// Returns every position at which a track appears in a list of playlist items
+func TrackPositions(items []PlaylistItem, trackID string) []int {
+	var positions []int
+	for i, item := range items {
+		if item.Track != nil && (item.Track.ID == trackID || item.Track.OriginalID() == trackID) {
+			positions = append(positions, i)
+		}
+	}
+	return positions
+}
+
+// Fetches the upcoming tracks in the user's playback queue, next track first
+func GetQueue(accessToken string) ([]Track, error) {
+	url := fmt.Sprintf("%s/me/player/queue", SpotifyAPIBaseURL)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	resp, err := spotifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to retrieve queue: %s", spotifyErrorMessage(body))
 	}
 
+	var data struct {
+		Queue []Track `json:"queue"`
+	}
 	err = json.NewDecoder(resp.Body).Decode(&data)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	for _, item := range data.Items {
-		if item.Track.ID == songID {
-			return true, nil
+	return data.Queue, nil
+}
+
+// Guesses which copy of a track is playing when it appears more than once in a playlist. Spotify
+// doesn't report the playing position, but the copy followed by the next queued track is the one
+// playing. Falls back to the first copy, and returns -1 if the track isn't in the playlist
+func FindPlayingPosition(items []PlaylistItem, trackID, nextTrackID string) int {
+	positions := TrackPositions(items, trackID)
+	if len(positions) == 0 {
+		return -1
+	}
+
+	if nextTrackID != "" {
+		for _, position := range positions {
+			next := position + 1
+			if next < len(items) && items[next].Track != nil && items[next].Track.ID == nextTrackID {
+				return position
+			}
 		}
 	}
 
-	return false, nil
+	return positions[0]
+}
+
+// Removes tracks from the user's Liked Songs
+func RemoveSavedTracks(accessToken string, trackIDs []string) error {
+	return modifySavedTracks(accessToken, "DELETE", trackIDs)
+}
+
+// Adds tracks to the user's Liked Songs
+func SaveTracks(accessToken string, trackIDs []string) error {
+	return modifySavedTracks(accessToken, "PUT", trackIDs)
+}
+
+func modifySavedTracks(accessToken, method string, trackIDs []string) error {
+	url := fmt.Sprintf("%s/me/tracks?ids=%s", SpotifyAPIBaseURL, strings.Join(trackIDs, ","))
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	resp, err := spotifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to update saved tracks: %s", spotifyErrorMessage(body))
+	}
+
+	return nil
+}
+
+// Creates a new playlist in the user's account
+func CreatePlaylist(accessToken, userID, name, description string, public bool) (*Playlist, error) {
+	url := fmt.Sprintf("%s/users/%s/playlists", SpotifyAPIBaseURL, userID)
+
+	body := map[string]interface{}{
+		"name":        name,
+		"description": description,
+		"public":      public,
+	}
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", url, strings.NewReader(string(jsonBody)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := spotifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 201 && resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to create playlist: %s", spotifyErrorMessage(body))
+	}
+
+	var playlist Playlist
+	err = json.NewDecoder(resp.Body).Decode(&playlist)
+	if err != nil {
+		return nil, err
+	}
+
+	return &playlist, nil
+}
+
+// Starts playing a playlist, album or artist at the given track position
+func StartPlayback(accessToken, contextURI string, position int) error {
+	url := fmt.Sprintf("%s/me/player/play", SpotifyAPIBaseURL)
+
+	body := map[string]interface{}{
+		"context_uri": contextURI,
+		"offset":      map[string]int{"position": position},
+	}
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", url, strings.NewReader(string(jsonBody)))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := spotifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to start playback: %s", spotifyErrorMessage(body))
+	}
+
+	return nil
+}
+
+// Moves the track at rangeStart so it sits before the track at insertBefore, pinned to the
+// given snapshot. Returns the playlist's new snapshot ID
+func ReorderPlaylistTrack(accessToken, playlistID string, rangeStart, insertBefore int, snapshotID string) (string, error) {
+	url := fmt.Sprintf("%s/playlists/%s/tracks", SpotifyAPIBaseURL, playlistID)
+
+	body := map[string]interface{}{
+		"range_start":   rangeStart,
+		"insert_before": insertBefore,
+	}
+	if snapshotID != "" {
+		body["snapshot_id"] = snapshotID
+	}
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("PUT", url, strings.NewReader(string(jsonBody)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := spotifyClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to reorder playlist: %s", spotifyErrorMessage(body))
+	}
+
+	var data struct {
+		SnapshotID string `json:"snapshot_id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&data)
+	if err != nil {
+		return "", err
+	}
+
+	return data.SnapshotID, nil
 }
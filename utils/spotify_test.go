@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roundTripFunc sends requests to a handler instead of the network
+type roundTripFunc func(*http.Request) *http.Response
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+// Answers requests to Spotify with handler for the rest of the test
+func fakeSpotify(t *testing.T, handler http.HandlerFunc) {
+	original := spotifyClient
+	spotifyClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) *http.Response {
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		return recorder.Result()
+	})}
+	t.Cleanup(func() { spotifyClient = original })
+}
+
+func playlistItems(trackIDs ...string) []PlaylistItem {
+	var items []PlaylistItem
+	for _, id := range trackIDs {
+		items = append(items, PlaylistItem{Track: &Track{ID: id}})
+	}
+	return items
+}
+
+func TestTrack_SavedURI(t *testing.T) {
+	relinked := Track{ID: "new", URI: "spotify:track:new"}
+	relinked.LinkedFrom = &struct {
+		ID string `json:"id"`
+	}{ID: "original"}
+	assert.Equal(t, "spotify:track:original", relinked.SavedURI())
+
+	episode := Track{ID: "512ojhOuo1ktJprKbVcKyQ", URI: "spotify:episode:512ojhOuo1ktJprKbVcKyQ"}
+	assert.Equal(t, "spotify:episode:512ojhOuo1ktJprKbVcKyQ", episode.SavedURI())
+}
+
+func TestTrackPositions(t *testing.T) {
+	items := playlistItems("a", "b", "a", "c")
+	items = append(items, PlaylistItem{Track: nil})
+	assert.Equal(t, []int{0, 2}, TrackPositions(items, "a"))
+	assert.Nil(t, TrackPositions(items, "z"))
+}
+
+func TestFindPlayingPosition(t *testing.T) {
+	items := playlistItems("a", "b", "c", "a", "d")
+
+	assert.Equal(t, 3, FindPlayingPosition(items, "a", "d"))
+	assert.Equal(t, 0, FindPlayingPosition(items, "a", "b"))
+	assert.Equal(t, 0, FindPlayingPosition(items, "a", ""))
+	assert.Equal(t, 0, FindPlayingPosition(items, "a", "unrelated"))
+	assert.Equal(t, 2, FindPlayingPosition(items, "c", ""))
+	assert.Equal(t, -1, FindPlayingPosition(items, "z", "a"))
+}
+
+func TestPlaylistPermissionFor(t *testing.T) {
+	owned := &Playlist{}
+	owned.Owner.ID = "user-1"
+	assert.Equal(t, PlaylistOwned, PlaylistPermissionFor(owned, "user-1"))
+
+	collaborative := &Playlist{Collaborative: true}
+	collaborative.Owner.ID = "friend"
+	assert.Equal(t, PlaylistCollaborative, PlaylistPermissionFor(collaborative, "user-1"))
+	assert.True(t, PlaylistPermissionFor(collaborative, "user-1").CanEdit())
+
+	readOnly := &Playlist{}
+	readOnly.Owner.ID = "spotify"
+	assert.Equal(t, PlaylistReadOnly, PlaylistPermissionFor(readOnly, "user-1"))
+	assert.False(t, PlaylistPermissionFor(readOnly, "user-1").CanEdit())
+	assert.False(t, PlaylistNotFound.CanEdit())
+}
+
+func TestCurrentlyPlaying_IsLikedSongs(t *testing.T) {
+	assert.True(t, (&CurrentlyPlaying{ContextType: "collection", ContextURI: "spotify:user:abc:collection"}).IsLikedSongs())
+	assert.True(t, (&CurrentlyPlaying{ContextURI: "spotify:user:abc:collection"}).IsLikedSongs())
+	assert.False(t, (&CurrentlyPlaying{ContextType: "playlist", ContextURI: "spotify:playlist:37i9dQZF1DXcBWIGoYBM5M"}).IsLikedSongs())
+	assert.False(t, (&CurrentlyPlaying{}).IsLikedSongs())
+}
+
+func TestGetCurrentlyPlayingItem_Episode(t *testing.T) {
+	fakeSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("additional_types") != "episode" {
+			// Without additional_types, Spotify leaves out a playing episode
+			w.Write([]byte(`{"item": null, "currently_playing_type": "episode"}`))
+			return
+		}
+		w.Write([]byte(`{"item": {"id": "512ojhOuo1ktJprKbVcKyQ", "name": "Episode 1", "uri": "spotify:episode:512ojhOuo1ktJprKbVcKyQ", "type": "episode", "show": {"name": "The Show"}}}`))
+	})
+
+	playing, err := GetCurrentlyPlayingItem("token")
+	assert.NoError(t, err)
+	if assert.NotNil(t, playing) {
+		assert.True(t, playing.Track.IsEpisode())
+		assert.Equal(t, "The Show", playing.Track.Show.Name)
+	}
+
+	// Other actions only work on songs
+	_, err = GetCurrentlyPlaying("token")
+	assert.Error(t, err)
+}
+
+func TestGetEpisodes(t *testing.T) {
+	fakeSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/episodes", r.URL.Path)
+		w.Write([]byte(`{"episodes": [{"id": "512ojhOuo1ktJprKbVcKyQ", "name": "Episode 1", "uri": "spotify:episode:512ojhOuo1ktJprKbVcKyQ", "explicit": true}, null]}`))
+	})
+
+	episodes, err := GetEpisodes("token", []string{"512ojhOuo1ktJprKbVcKyQ", "missing"})
+	assert.NoError(t, err)
+	if assert.Len(t, episodes, 1) {
+		assert.True(t, episodes[0].IsEpisode())
+		assert.Equal(t, "podcast episodes aren't allowed", PlaylistSettings{BlockEpisodes: true}.CheckPolicy(episodes[0]))
+	}
+}
+
+func TestIsFollowingPlaylist(t *testing.T) {
+	following := "[true]"
+	fakeSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/playlists/playlist-1/followers/contains", r.URL.Path)
+		assert.Equal(t, "user-1", r.URL.Query().Get("ids"))
+		w.Write([]byte(following))
+	})
+
+	ok, err := IsFollowingPlaylist("token", "playlist-1", "user-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// What Spotify answers once the owner has deleted the playlist
+	following = "[false]"
+	ok, err = IsFollowingPlaylist("token", "playlist-1", "user-1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,458 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Formats of webhook bodies
+const (
+	// WebhookFormatJSON posts the WebhookEvent itself
+	WebhookFormatJSON = "json"
+	// WebhookFormatDiscord posts a message that a Discord channel webhook accepts
+	WebhookFormatDiscord = "discord"
+)
+
+// WebhookTest is the type of the event sent by the test-fire endpoint
+const WebhookTest = "test"
+
+// Maximum number of webhooks per user
+const MaxWebhooks = 10
+
+// Number of times a delivery is attempted before it is marked as failed
+const MaxWebhookAttempts = 5
+
+// Number of deliveries kept in each user's delivery log
+const maxWebhookDeliveries = 100
+
+// How long a webhook has to respond
+const webhookTimeout = 3 * time.Second
+
+// Delay before each retry. The last one is reused if there are more attempts than delays
+var webhookRetryDelays = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+// errPrivateWebhookAddress is returned for a webhook whose host is loopback, private, link-local
+// or unspecified, so webhooks can't be used to reach the deployment's own network
+var errPrivateWebhookAddress = errors.New("webhooks can't be sent to private or local addresses")
+
+// Resolves webhook hosts when they are registered
+var lookupWebhookHost = net.LookupIP
+
+// Sends webhook deliveries, refusing to connect to private addresses. The host is checked again
+// at each connection, since its DNS records can change after it was registered
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: webhookTimeout, Control: checkWebhookDial}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+	},
+}
+
+// Reports whether a webhook may be sent to an address
+func isPublicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsUnspecified()
+}
+
+// Checks the address a delivery is about to connect to
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicAddress(ip) {
+		return errPrivateWebhookAddress
+	}
+	return nil
+}
+
+// Webhook is a URL that is sent an event after each playlist action
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret signs each delivery. It is only shown when the webhook is created
+	Secret string `json:"secret,omitempty"`
+	// Events lists the event types to send, e.g. ["add"]. Empty means all of them
+	Events    []string  `json:"events,omitempty"`
+	Format    string    `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewWebhook creates a webhook with a new ID and secret
+func NewWebhook(webhookURL string, events []string, format string) (Webhook, error) {
+	if format == "" {
+		format = WebhookFormatJSON
+	}
+	webhook := Webhook{URL: webhookURL, Events: events, Format: format, CreatedAt: time.Now().UTC()}
+	err := webhook.Validate()
+	if err != nil {
+		return webhook, err
+	}
+
+	webhook.ID, err = randomHex(8)
+	if err != nil {
+		return webhook, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return webhook, err
+	}
+	webhook.Secret = "whsec_" + secret
+
+	return webhook, nil
+}
+
+// Validate checks that the webhook has an HTTPS URL whose host resolves to public addresses only,
+// a known format and known event types
+func (w Webhook) Validate() error {
+	parsed, err := url.Parse(w.URL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("invalid url %q: use an https:// URL", w.URL)
+	}
+	if w.Format != WebhookFormatJSON && w.Format != WebhookFormatDiscord {
+		return fmt.Errorf("invalid format %q: use %q or %q", w.Format, WebhookFormatJSON, WebhookFormatDiscord)
+	}
+	for _, event := range w.Events {
+		switch event {
+		case HistoryAdd, HistoryRemove, HistoryLike, HistorySkip, HistoryUndo:
+		default:
+			return fmt.Errorf("invalid event %q: use %q, %q, %q, %q or %q", event, HistoryAdd, HistoryRemove, HistoryLike, HistorySkip, HistoryUndo)
+		}
+	}
+
+	ips := []net.IP{net.ParseIP(parsed.Hostname())}
+	if ips[0] == nil {
+		ips, err = lookupWebhookHost(parsed.Hostname())
+		if err != nil || len(ips) == 0 {
+			return fmt.Errorf("invalid url %q: its host could not be resolved", w.URL)
+		}
+	}
+	for _, ip := range ips {
+		if !isPublicAddress(ip) {
+			return fmt.Errorf("invalid url %q: %v", w.URL, errPrivateWebhookAddress)
+		}
+	}
+	return nil
+}
+
+// Wants reports whether the webhook is sent events of the given type. Test events go to every
+// webhook
+func (w Webhook) Wants(eventType string) bool {
+	if len(w.Events) == 0 || eventType == WebhookTest {
+		return true
+	}
+	for _, event := range w.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is the JSON body of a webhook delivery
+type WebhookEvent struct {
+	// ID is the same for every attempt to deliver the event, so receivers can ignore repeats
+	ID        string           `json:"id"`
+	Type      string           `json:"type"`
+	Timestamp time.Time        `json:"timestamp"`
+	Track     *WebhookTrack    `json:"track,omitempty"`
+	Playlist  *WebhookPlaylist `json:"playlist,omitempty"`
+	// Result is what happened, as in the history, e.g. "added" or "duplicate"
+	Result string `json:"result,omitempty"`
+}
+
+type WebhookTrack struct {
+	URI  string `json:"uri"`
+	Name string `json:"name,omitempty"`
+}
+
+type WebhookPlaylist struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// NewWebhookEvent converts a history event for webhooks, giving it an ID and the current time
+func NewWebhookEvent(event HistoryEvent) (WebhookEvent, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	webhookEvent := WebhookEvent{ID: id, Type: event.Type, Timestamp: time.Now().UTC(), Result: event.Result}
+	if event.Track != "" {
+		webhookEvent.Track = &WebhookTrack{URI: event.Track, Name: event.TrackName}
+	}
+	if event.PlaylistID != "" {
+		webhookEvent.Playlist = &WebhookPlaylist{ID: event.PlaylistID, Name: event.PlaylistName}
+	}
+	return webhookEvent, nil
+}
+
+// Describes the event as a chat message, e.g. "Added Yellow to Gym"
+func (e WebhookEvent) Describe() string {
+	track, playlist := "a song", "a playlist"
+	if e.Track != nil && e.Track.Name != "" {
+		track = e.Track.Name
+	}
+	if e.Playlist != nil && e.Playlist.Name != "" {
+		playlist = e.Playlist.Name
+	}
+
+	switch e.Type {
+	case WebhookTest:
+		return "Test event from Siri Playlist Actions"
+	case HistoryAdd:
+		if e.Result != "added" {
+			return fmt.Sprintf("Didn't add %s to %s (%s)", track, playlist, e.Result)
+		}
+		return fmt.Sprintf("Added %s to %s", track, playlist)
+	case HistoryRemove:
+		if e.Result == "error" {
+			return fmt.Sprintf("Couldn't remove %s from %s", track, playlist)
+		}
+		return fmt.Sprintf("Removed %s from %s", track, playlist)
+	case HistoryLike:
+		switch e.Result {
+		case "liked":
+			return fmt.Sprintf("Liked %s", track)
+		case "unliked":
+			return fmt.Sprintf("Removed %s from Liked Songs", track)
+		}
+		return fmt.Sprintf("Couldn't change %s in Liked Songs", track)
+	case HistorySkip:
+		return fmt.Sprintf("Skipped %s", track)
+	}
+	return fmt.Sprintf("Undid a change to %s in %s", track, playlist)
+}
+
+// SignWebhook computes the X-Webhook-Signature of a delivery: the hex HMAC-SHA256, keyed with
+// the webhook's secret, of the timestamp, a period and the body
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliverWebhook posts an event to a webhook. Returns the response's status code, if there was
+// one, and an error unless it was a 2xx
+func DeliverWebhook(webhook Webhook, event WebhookEvent) (int, error) {
+	var body []byte
+	var err error
+	if webhook.Format == WebhookFormatDiscord {
+		body, err = json.Marshal(map[string]string{"content": event.Describe()})
+	} else {
+		body, err = json.Marshal(event)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("User-Agent", "siri-playlist-actions")
+	req.Header.Add("X-Webhook-Id", event.ID)
+	req.Header.Add("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Add("X-Webhook-Signature", SignWebhook(webhook.Secret, timestamp, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// The body isn't kept: the delivery log is shown to the user, and it mustn't reveal what a
+	// URL returns
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// WebhookJob is a pending delivery of an event to one of a user's webhooks
+type WebhookJob struct {
+	UserID    string       `json:"user_id"`
+	WebhookID string       `json:"webhook_id"`
+	Event     WebhookEvent `json:"event"`
+	// Attempt is the number of attempts already made
+	Attempt int `json:"attempt"`
+}
+
+// RetryDelay is how long to wait after the job's latest attempt
+func (j WebhookJob) RetryDelay() time.Duration {
+	index := min(max(j.Attempt-1, 0), len(webhookRetryDelays)-1)
+	return webhookRetryDelays[index]
+}
+
+// WebhookDelivery records an attempt to deliver an event, for the delivery log
+type WebhookDelivery struct {
+	EventID   string    `json:"event_id"`
+	EventType string    `json:"event_type"`
+	WebhookID string    `json:"webhook_id"`
+	Attempt   int       `json:"attempt"`
+	Time      time.Time `json:"time"`
+	// Status is "delivered", "retrying" or "failed"
+	Status     string `json:"status"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Retrieves a user's webhooks, secrets included
+func GetWebhooks(userID string, conn redis.Conn) ([]Webhook, error) {
+	defer conn.Close()
+
+	webhooks := []Webhook{}
+	data, err := redis.Bytes(conn.Do("GET", fmt.Sprintf("webhooks:%s", userID)))
+	if err == redis.ErrNil {
+		return webhooks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve webhooks: %v", err)
+	}
+
+	err = json.Unmarshal(data, &webhooks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhooks: %v", err)
+	}
+
+	return webhooks, nil
+}
+
+// Stores a user's webhooks
+func SetWebhooks(userID string, webhooks []Webhook, conn redis.Conn) error {
+	defer conn.Close()
+
+	data, err := json.Marshal(webhooks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhooks: %v", err)
+	}
+
+	_, err = conn.Do("SET", fmt.Sprintf("webhooks:%s", userID), data)
+	if err != nil {
+		return fmt.Errorf("failed to store webhooks: %v", err)
+	}
+
+	return nil
+}
+
+// Queues a job to be attempted at the given time
+func QueueWebhookJob(job WebhookJob, at time.Time, conn redis.Conn) error {
+	defer conn.Close()
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook job: %v", err)
+	}
+
+	_, err = conn.Do("ZADD", "webhook-queue", at.UnixMilli(), data)
+	if err != nil {
+		return fmt.Errorf("failed to queue webhook job: %v", err)
+	}
+
+	return nil
+}
+
+// Takes up to limit jobs that are due from the queue. A job is only taken once, even when the
+// queue is processed by several requests at a time
+func ClaimWebhookJobs(now time.Time, limit int, conn redis.Conn) ([]WebhookJob, error) {
+	defer conn.Close()
+
+	members, err := redis.ByteSlices(conn.Do("ZRANGEBYSCORE", "webhook-queue", "-inf", now.UnixMilli(), "LIMIT", 0, limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve webhook jobs: %v", err)
+	}
+
+	var jobs []WebhookJob
+	for _, member := range members {
+		// Whoever removes the job gets to run it
+		removed, err := redis.Int(conn.Do("ZREM", "webhook-queue", member))
+		if err != nil {
+			return jobs, fmt.Errorf("failed to claim webhook job: %v", err)
+		}
+		if removed == 0 {
+			continue
+		}
+
+		var job WebhookJob
+		err = json.Unmarshal(member, &job)
+		if err != nil {
+			return jobs, fmt.Errorf("failed to unmarshal webhook job: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// Adds a delivery to the front of a user's delivery log, dropping the oldest beyond its limit
+func LogWebhookDelivery(userID string, delivery WebhookDelivery, conn redis.Conn) error {
+	defer conn.Close()
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook delivery: %v", err)
+	}
+
+	key := fmt.Sprintf("webhook-deliveries:%s", userID)
+	_, err = conn.Do("LPUSH", key, data)
+	if err != nil {
+		return fmt.Errorf("failed to log webhook delivery: %v", err)
+	}
+	_, err = conn.Do("LTRIM", key, 0, maxWebhookDeliveries-1)
+	if err != nil {
+		return fmt.Errorf("failed to trim webhook deliveries: %v", err)
+	}
+
+	return nil
+}
+
+// Retrieves a user's delivery log, newest first
+func GetWebhookDeliveries(userID string, conn redis.Conn) ([]WebhookDelivery, error) {
+	defer conn.Close()
+
+	members, err := redis.ByteSlices(conn.Do("LRANGE", fmt.Sprintf("webhook-deliveries:%s", userID), 0, -1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve webhook deliveries: %v", err)
+	}
+
+	deliveries := []WebhookDelivery{}
+	for _, member := range members {
+		var delivery WebhookDelivery
+		err = json.Unmarshal(member, &delivery)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal webhook delivery: %v", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+func randomHex(n int) (string, error) {
+	data := make([]byte, n)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Resolves hosts to the given addresses and any others to a public one, so tests don't depend
+// on DNS
+func fakeWebhookDNS(t *testing.T, hosts map[string]string) {
+	original := lookupWebhookHost
+	lookupWebhookHost = func(host string) ([]net.IP, error) {
+		if address, ok := hosts[host]; ok {
+			return []net.IP{net.ParseIP(address)}, nil
+		}
+		return []net.IP{net.ParseIP("93.184.215.14")}, nil
+	}
+	t.Cleanup(func() { lookupWebhookHost = original })
+}
+
+// Lets deliveries reach test servers, which listen on loopback
+func allowLocalWebhooks(t *testing.T) {
+	original := webhookClient
+	webhookClient = &http.Client{Timeout: webhookTimeout}
+	t.Cleanup(func() { webhookClient = original })
+}
+
+func TestNewWebhook(t *testing.T) {
+	fakeWebhookDNS(t, nil)
+	webhook, err := NewWebhook("https://example.com/hook", []string{HistoryAdd}, "")
+	require.NoError(t, err)
+	assert.NotEmpty(t, webhook.ID)
+	assert.Contains(t, webhook.Secret, "whsec_")
+	assert.Equal(t, WebhookFormatJSON, webhook.Format)
+	assert.True(t, webhook.Wants(HistoryAdd))
+	assert.True(t, webhook.Wants(WebhookTest))
+	assert.False(t, webhook.Wants(HistoryRemove))
+
+	_, err = NewWebhook("http://example.com/hook", nil, "")
+	assert.Error(t, err)
+	_, err = NewWebhook("https://example.com/hook", []string{HistoryLike}, "")
+	assert.NoError(t, err)
+	_, err = NewWebhook("https://example.com/hook", []string{"play"}, "")
+	assert.Error(t, err)
+	_, err = NewWebhook("https://example.com/hook", nil, "slack")
+	assert.Error(t, err)
+}
+
+func TestNewWebhook_RejectsPrivateAddresses(t *testing.T) {
+	fakeWebhookDNS(t, map[string]string{"internal.example.com": "10.0.0.5"})
+
+	for _, webhookURL := range []string{
+		"https://127.0.0.1/hook",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/hook",
+		"https://0.0.0.0/hook",
+		"https://internal.example.com/hook",
+	} {
+		_, err := NewWebhook(webhookURL, nil, "")
+		assert.Error(t, err, webhookURL)
+	}
+}
+
+func TestDeliverWebhook_RefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("delivery reached a loopback address")
+	}))
+	defer server.Close()
+
+	event, err := NewWebhookEvent(HistoryEvent{Type: HistorySkip, Track: "spotify:track:1"})
+	require.NoError(t, err)
+
+	_, err = DeliverWebhook(Webhook{URL: server.URL, Format: WebhookFormatJSON}, event)
+	assert.ErrorContains(t, err, errPrivateWebhookAddress.Error())
+}
+
+func TestDeliverWebhook_LeavesOutResponseBody(t *testing.T) {
+	allowLocalWebhooks(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal secret", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	event, err := NewWebhookEvent(HistoryEvent{Type: HistorySkip, Track: "spotify:track:1"})
+	require.NoError(t, err)
+
+	statusCode, err := DeliverWebhook(Webhook{URL: server.URL, Format: WebhookFormatJSON}, event)
+	assert.Equal(t, http.StatusForbidden, statusCode)
+	assert.EqualError(t, err, "webhook responded with 403")
+}
+
+func TestDeliverWebhook_Signed(t *testing.T) {
+	allowLocalWebhooks(t)
+	var received WebhookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-Webhook-Timestamp"), 10, 64)
+		if r.Header.Get("X-Webhook-Signature") != SignWebhook("whsec_test", timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	event, err := NewWebhookEvent(HistoryEvent{Type: HistoryAdd, Track: "spotify:track:1", TrackName: "Yellow", PlaylistID: "p1", PlaylistName: "Gym", Result: "added"})
+	require.NoError(t, err)
+
+	statusCode, err := DeliverWebhook(Webhook{URL: server.URL, Secret: "whsec_test", Format: WebhookFormatJSON}, event)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, event.ID, received.ID)
+	assert.Equal(t, "Yellow", received.Track.Name)
+	assert.Equal(t, "Gym", received.Playlist.Name)
+
+	statusCode, err = DeliverWebhook(Webhook{URL: server.URL, Secret: "wrong", Format: WebhookFormatJSON}, event)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, statusCode)
+}
+
+func TestDeliverWebhook_Discord(t *testing.T) {
+	allowLocalWebhooks(t)
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	event, err := NewWebhookEvent(HistoryEvent{Type: HistoryAdd, Track: "spotify:track:1", TrackName: "Yellow", PlaylistID: "p1", PlaylistName: "Gym", Result: "duplicate"})
+	require.NoError(t, err)
+
+	_, err = DeliverWebhook(Webhook{URL: server.URL, Format: WebhookFormatDiscord}, event)
+	require.NoError(t, err)
+	assert.Equal(t, "Didn't add Yellow to Gym (duplicate)", received["content"])
+}
+
+func TestWebhookEvent_DescribeLike(t *testing.T) {
+	event, err := NewWebhookEvent(HistoryEvent{Type: HistoryLike, Track: "spotify:track:1", TrackName: "Yellow", Result: "unliked"})
+	require.NoError(t, err)
+	assert.Equal(t, "Removed Yellow from Liked Songs", event.Describe())
+	assert.Nil(t, event.Playlist)
+}
+
+func TestWebhookQueue_ClaimsDueJobsOnce(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+	now := time.Now()
+	due := WebhookJob{UserID: "user-1", WebhookID: "w1", Event: WebhookEvent{ID: "e1"}, Attempt: 1}
+	later := WebhookJob{UserID: "user-1", WebhookID: "w1", Event: WebhookEvent{ID: "e2"}, Attempt: 1}
+
+	require.NoError(t, QueueWebhookJob(due, now.Add(-time.Minute), mock))
+	require.NoError(t, QueueWebhookJob(later, now.Add(time.Hour), mock))
+
+	jobs, err := ClaimWebhookJobs(now, 10, mock)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "e1", jobs[0].Event.ID)
+
+	jobs, err = ClaimWebhookJobs(now, 10, mock)
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
+
+	assert.Equal(t, time.Minute, due.RetryDelay())
+	assert.Equal(t, 2*time.Hour, WebhookJob{Attempt: MaxWebhookAttempts}.RetryDelay())
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	mock := &mockConn{data: map[string][]byte{}}
+
+	require.NoError(t, LogWebhookDelivery("user-1", WebhookDelivery{EventID: "e1", Status: "retrying"}, mock))
+	require.NoError(t, LogWebhookDelivery("user-1", WebhookDelivery{EventID: "e1", Status: "delivered"}, mock))
+
+	deliveries, err := GetWebhookDeliveries("user-1", mock)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, "delivered", deliveries[0].Status)
+}